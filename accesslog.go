@@ -0,0 +1,162 @@
+package gluapp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Access log formats (`Config.AccessLogFormat`)
+const (
+	AccessLogCommon   = "common"   // Common Log Format
+	AccessLogCombined = "combined" // Combined Log Format (CLF with the referer and the user agent)
+	AccessLogJSON     = "json"     // JSON lines, with the latency and the matched route
+)
+
+// accessLogEntry represents a request served by `App.ServeHTTP`
+type accessLogEntry struct {
+	Time       time.Time
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Latency    time.Duration
+	RemoteAddr string
+	User       string
+	Route      string
+	Referer    string
+	UserAgent  string
+	RequestID  string
+}
+
+// loggingResponseWriter records the status code and the size of the response
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *loggingResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements `http.Flusher` (used when proxying)
+func (w *loggingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements `http.Hijacker` (used when proxying an upgrade request)
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// newAccessLogEntry returns the entry for the served request
+func newAccessLogEntry(r *http.Request, w *loggingResponseWriter, resp *Response, start time.Time) *accessLogEntry {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	user, _, _ := r.BasicAuth()
+	entry := &accessLogEntry{
+		Time:       start,
+		Method:     r.Method,
+		URI:        uri,
+		Proto:      r.Proto,
+		Status:     w.status,
+		Bytes:      w.bytes,
+		Latency:    time.Since(start),
+		RemoteAddr: getIPAddress(r),
+		User:       user,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		RequestID:  r.Header.Get("X-Request-Id"),
+	}
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	if resp != nil {
+		entry.Route = resp.route
+	}
+	return entry
+}
+
+// clfValue returns the value, or "-" if it's empty
+func clfValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// format returns the log line (without the trailing newline) in the given format
+func (e *accessLogEntry) format(format string) ([]byte, error) {
+	switch format {
+	case "", AccessLogCommon, AccessLogCombined:
+		size := "-"
+		if e.Bytes > 0 {
+			size = strconv.FormatInt(e.Bytes, 10)
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %s", clfValue(e.RemoteAddr), clfValue(e.User),
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method+" "+e.URI+" "+e.Proto, e.Status, size)
+		if format == AccessLogCombined {
+			line += fmt.Sprintf(" %q %q", clfValue(e.Referer), clfValue(e.UserAgent))
+		}
+		return []byte(line), nil
+	case AccessLogJSON:
+		return json.Marshal(map[string]interface{}{
+			"time":        e.Time.Format(time.RFC3339Nano),
+			"method":      e.Method,
+			"uri":         e.URI,
+			"proto":       e.Proto,
+			"status":      e.Status,
+			"bytes":       e.Bytes,
+			"latency":     e.Latency.Seconds(),
+			"remote_addr": e.RemoteAddr,
+			"user":        e.User,
+			"route":       e.Route,
+			"referer":     e.Referer,
+			"user_agent":  e.UserAgent,
+			"request_id":  e.RequestID,
+		})
+	}
+	return nil, fmt.Errorf("unknown access log format %q", format)
+}
+
+// writeAccessLog writes the entry to `Config.AccessLog`
+func (a *App) writeAccessLog(entry *accessLogEntry) {
+	// The format is checked by `NewApp`
+	line, err := entry.format(a.conf.AccessLogFormat)
+	if err != nil {
+		panic(err)
+	}
+	a.accessLogMu.Lock()
+	defer a.accessLogMu.Unlock()
+	a.conf.AccessLog.Write(append(line, '\n'))
+}
@@ -0,0 +1,164 @@
+package gluapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestAccessLog(t *testing.T) {
+	testData := []struct {
+		conf                           *Config
+		method, path, userAgent        string
+		expectedStatus, expectedLength int
+		expected                       string // Regexp
+	}{
+		{&Config{Path: "tests_data/app/", AccessLogFormat: AccessLogCommon}, "GET", "/bar?q=1", "test/1.0", 200, 3,
+			`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /bar\?q=1 HTTP/1\.1" 200 3$`},
+		// The public files are logged too
+		{&Config{Path: "tests_data/app/", AccessLogFormat: AccessLogCombined}, "GET", "/js/app.js", "test/1.0", 200, 22,
+			`^192\.0\.2\.1 - - \[.+\] "GET /js/app\.js HTTP/1\.1" 200 22 "-" "test/1\.0"$`},
+		{&Config{Path: "tests_data/app/"}, "GET", "/nope", "", 404, 9,
+			`^192\.0\.2\.1 - - \[.+\] "GET /nope HTTP/1\.1" 404 9$`},
+	}
+
+	for _, tdata := range testData {
+		var buf bytes.Buffer
+		tdata.conf.AccessLog = &buf
+		app, err := NewApp(tdata.conf)
+		if err != nil {
+			panic(err)
+		}
+		r := httptest.NewRequest(tdata.method, tdata.path, nil)
+		if tdata.userAgent != "" {
+			r.Header.Set("User-Agent", tdata.userAgent)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != tdata.expectedStatus || w.Body.Len() != tdata.expectedLength {
+			t.Errorf("%s: bad response, got %d %d", tdata.path, w.Code, w.Body.Len())
+		}
+		line := strings.TrimSuffix(buf.String(), "\n")
+		if !regexp.MustCompile(tdata.expected).MatchString(line) {
+			t.Errorf("%s: bad access log line, got %q", tdata.path, line)
+		}
+	}
+}
+
+func TestAccessLogError(t *testing.T) {
+	var buf bytes.Buffer
+	app, err := NewApp(&Config{Path: "tests_data/app/", AccessLog: &buf})
+	if err != nil {
+		panic(err)
+	}
+	app.Use(func(next Handler) Handler {
+		return func(r *http.Request, L *lua.LState, resp *Response) error {
+			return errors.New("failed")
+		}
+	})
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("the error should be propagated")
+			}
+		}()
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bar", nil))
+	}()
+	line := strings.TrimSuffix(buf.String(), "\n")
+	if !regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /bar HTTP/1\.1" 500 -$`).MatchString(line) {
+		t.Errorf("bad access log line, got %q", line)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	testData := []struct {
+		conf          *Config
+		method, path  string
+		expectedRoute string
+	}{
+		{&Config{Path: "tests_data/app/"}, "POST", "/posts/42", "/posts/:id"},
+		{&Config{Path: "tests_data/pages_app/", Pages: true}, "GET", "/blog/hello-world", "/blog/:slug"},
+		{&Config{Path: "tests_data/app/"}, "GET", "/js/app.js", ""},
+	}
+
+	for _, tdata := range testData {
+		var buf bytes.Buffer
+		tdata.conf.AccessLog = &buf
+		tdata.conf.AccessLogFormat = AccessLogJSON
+		app, err := NewApp(tdata.conf)
+		if err != nil {
+			panic(err)
+		}
+		r := httptest.NewRequest(tdata.method, tdata.path, nil)
+		r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		r.Header.Set("User-Agent", "test/1.0")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: invalid JSON line %q: %v", tdata.path, buf.String(), err)
+		}
+		if entry["method"] != tdata.method || entry["uri"] != tdata.path || entry["status"] != float64(w.Code) ||
+			entry["bytes"] != float64(w.Body.Len()) || entry["route"] != tdata.expectedRoute ||
+			entry["remote_addr"] != "203.0.113.7" || entry["user_agent"] != "test/1.0" {
+			t.Errorf("%s: bad entry, got %+v", tdata.path, entry)
+		}
+		if latency, ok := entry["latency"].(float64); !ok || latency <= 0 {
+			t.Errorf("%s: bad latency, got %v", tdata.path, entry["latency"])
+		}
+		if id, ok := entry["request_id"].(string); !ok || id == "" {
+			t.Errorf("%s: missing request ID", tdata.path)
+		}
+		if r.Header.Get("X-Request-Id") != "" {
+			t.Errorf("%s: the request should not be modified", tdata.path)
+		}
+	}
+
+	if _, err := NewApp(&Config{Path: "tests_data/app/", AccessLogFormat: "nope"}); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestLoggingResponseWriterHijack(t *testing.T) {
+	var lw *loggingResponseWriter
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw = &loggingResponseWriter{ResponseWriter: w}
+		conn, rw, err := lw.Hijack()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || lw.status != http.StatusSwitchingProtocols {
+		t.Errorf("bad status, got %d/%d", resp.StatusCode, lw.status)
+	}
+
+	// The wrapped writer may not support it
+	if _, _, err := (&loggingResponseWriter{ResponseWriter: httptest.NewRecorder()}).Hijack(); err == nil {
+		t.Errorf("expected an error for a writer that can't be hijacked")
+	}
+}
@@ -1,12 +1,17 @@
 package gluapp
 
 import (
+	"archive/zip"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"a4.io/blobstash/pkg/apps/luautil"
 	"github.com/yuin/gopher-lua"
 )
 
@@ -14,58 +19,153 @@ import (
 type App struct {
 	ls            *lua.LState
 	conf          *Config
-	publicIndex   map[string]struct{}
+	fsys          fs.FS // Filesystem the app files are read from
+	bundled       bool  // True if the app is not loaded from `conf.Path`
+	publicIndex   map[string]*publicFile
+	assets        *assets
+	pagesIndex    *router
 	appEntrypoint string
+	staticMounts  []*staticMount
+	cache         *Cache
+	middlewares   []Middleware
+	archive       *zip.ReadCloser // Zip archive the app is read from (closed by `Close`)
+
+	minifyMu    sync.Mutex
+	minifyCache map[string][]byte
+
+	accessLogMu sync.Mutex
+
+	openAPIMu  sync.Mutex
+	openAPIDoc []byte // Generated on first use
 }
 
-func NewApp(conf *Config) (*App, error) {
+func NewApp(conf *Config) (_ *App, err error) {
 	// Make some sanity checks
-	if conf.Path == "" {
+	if conf.Path == "" && conf.FS == nil {
 		return nil, fmt.Errorf("missing `conf.Path`")
 	}
+	switch conf.AccessLogFormat {
+	case "", AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", conf.AccessLogFormat)
+	}
+
+	// Select the filesystem to read the app from
+	var fsys fs.FS
+	var archive *zip.ReadCloser
+	switch {
+	case conf.FS != nil:
+		fsys = conf.FS
+	case strings.HasSuffix(conf.Path, ".zip"):
+		archive, err = zip.OpenReader(conf.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				archive.Close()
+			}
+		}()
+		fsys = archive
+	}
+
 	epoint := "app.lua"
 	if conf.Entrypoint != "" {
 		epoint = conf.Entrypoint
 	}
-	appPath := filepath.Join(conf.Path, epoint)
-	if _, err := os.Stat(appPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("app entrypoint not found (%s)", appPath)
-	}
 
 	// Initialize the app
 	app := &App{
 		conf:          conf,
-		publicIndex:   map[string]struct{}{},
-		appEntrypoint: appPath,
+		fsys:          appFS(conf, fsys),
+		bundled:       fsys != nil,
+		publicIndex:   map[string]*publicFile{},
+		appEntrypoint: epoint,
+		minifyCache:   map[string][]byte{},
+		cache:         conf.Cache,
+		archive:       archive,
+	}
+	if app.cache == nil {
+		app.cache = NewCache(defaultCacheMaxSize)
 	}
 
-	// If there's a public dir, fetch the list of files and keep them in an index
-	publicPath, err := filepath.Abs(filepath.Join(conf.Path, "public"))
+	if _, err := fs.Stat(app.fsys, epoint); !conf.Pages && errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("app entrypoint not found (%s)", app.chunkName(epoint))
+	}
+
+	// The mounts of the apps loaded from a directory may point outside of it
+	var staticBase string
+	if !app.bundled {
+		staticBase = conf.Path
+	}
+	staticMounts, err := newStaticMounts(app.fsys, staticBase, conf.StaticMounts, conf.StaticDirListing)
 	if err != nil {
 		return nil, err
 	}
-	_, err = os.Stat(publicPath)
+	app.staticMounts = staticMounts
+
+	// In pages mode, build the index of pages (the entrypoint is not used)
+	if conf.Pages {
+		if _, err := fs.Stat(app.fsys, "pages"); errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("pages directory not found (%s)", app.chunkName("pages"))
+		}
+		pagesIndex, err := buildPagesIndex(app.fsys, "pages", conf.PagesPathPolicy)
+		if err != nil {
+			return nil, err
+		}
+		app.pagesIndex = pagesIndex
+	}
+
+	// If there's a public dir, fetch the list of files and keep them in an index
+	_, err = fs.Stat(app.fsys, "public")
 	switch {
 	case err == nil:
-		if err := filepath.Walk(publicPath, func(path string, f os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !f.IsDir() {
-				app.publicIndex[strings.Replace(path, publicPath, "", 1)] = struct{}{}
-			}
-			return nil
-		}); err != nil {
+		publicIndex, err := buildPublicIndex(app.fsys, "public")
+		if err != nil {
 			return nil, err
 		}
-	case os.IsNotExist(err):
+		app.publicIndex = publicIndex
+		app.assets = newAssets(publicIndex)
+	case errors.Is(err, fs.ErrNotExist):
 	default:
 		return nil, err
 	}
 
+	// The `kv` database and the `sql`/`redis` pools may be shared with other apps, they're closed when the last one is
+	// closed
+	if err := acquireKV(kvPath(conf)); err != nil {
+		return nil, err
+	}
+	acquireDatabases(conf)
+	if conf.Redis != nil {
+		conf.Redis.acquire()
+	}
+
 	return app, nil
 }
 
+// stdlib returns the modules to install for a request
+func (a *App) stdlib() *Stdlib {
+	s := NewStdlib(a.conf).All()
+	s.fsys = a.setupFS()
+	s.assets = a.assets
+	s.cache = a.cache
+	return s
+}
+
+// chunkName returns the name of the file for error messages
+func (a *App) chunkName(name string) string {
+	return filepath.Join(a.conf.Path, name)
+}
+
+// setupFS returns the filesystem to pass to `setupState`
+func (a *App) setupFS() fs.FS {
+	if a.bundled {
+		return a.fsys
+	}
+	return nil
+}
+
 // Exec executes the app in the given context, but it does not write the output to the `http.ResponseWriter`,
 // you need to call `Response.WriteTo(w)` manually.
 //
@@ -74,45 +174,213 @@ func (a *App) Exec(w http.ResponseWriter, r *http.Request) (*Response, error) {
 	path := r.URL.Path
 
 	// First check if there the request match a file in public/
-	if _, ok := a.publicIndex[path]; ok {
-		http.ServeFile(w, r, filepath.Join(a.conf.Path, "public", path))
+	if f, ok := a.publicIndex[path]; ok {
+		a.servePublic(w, r, path, f, false)
 		return nil, nil
 	}
 
+	// Or a fingerprinted path (that can be cached forever)
+	if orig, ok := a.assets.file(path); ok {
+		a.servePublic(w, r, orig, a.publicIndex[orig], true)
+		return nil, nil
+	}
+
+	// Then the static mounts
+	for _, m := range a.staticMounts {
+		if name, ok := m.match(path); ok && m.serve(w, r, name) {
+			return nil, nil
+		}
+	}
+
+	// Serve the generated OpenAPI document if enabled
+	if a.conf.OpenAPIPath != "" && path == a.conf.OpenAPIPath {
+		return a.serveOpenAPI(r)
+	}
+
+	// In pages mode, find the file matching the path
+	entrypoint := a.appEntrypoint
+	var pageParams params
+	var pageRoute string
+	if a.pagesIndex != nil {
+		page, params, canonical, err := a.pagesIndex.resolveRoute(r.Method, path)
+		if err != errNotFound && canonical != path && a.pagesIndex.pathPolicy == pathPolicyRedirect {
+			resp := &Response{Header: http.Header{}, req: r}
+			redirectTo(resp, r.Method, canonical)
+			return resp, nil
+		}
+		switch err {
+		case nil:
+		case errNotFound:
+			statusCode := http.StatusNotFound
+			return &Response{
+				Body:       []byte(http.StatusText(statusCode)),
+				Header:     http.Header{},
+				StatusCode: statusCode,
+				req:        r,
+			}, nil
+		default:
+			return nil, err
+		}
+		entrypoint = page.data.(string)
+		pageParams = params
+		pageRoute = page.pattern
+	}
+
 	// Initialize a Lua state
+	luaStart := time.Now()
 	L := lua.NewState()
 	defer L.Close()
 
 	// Preload all the modules and setup global variables
-	resp, err := setupState(L, a.conf, w, r)
+	resp, err := setupState(L, a.conf, a.stdlib(), w, r)
 	if err != nil {
 		return nil, err
 	}
 
-	// Now we can execute the app entrypoint `app.lua`
-	if err := L.DoFile(a.appEntrypoint); err != nil {
-		// TODO(tsileo): display a nice stack trace in debug mode
-		return nil, err
+	// Expose the page named parameters as `app.params`
+	if a.pagesIndex != nil {
+		resp.route = pageRoute
+		p := map[string]interface{}{}
+		for k, v := range pageParams {
+			p[k] = v
+		}
+		L.GetGlobal("app").(*lua.LTable).RawSetH(lua.LString("params"), luautil.InterfaceToLValue(L, p))
 	}
 
-	if a.conf.AfterScriptExecHook != nil {
-		if err := a.conf.AfterScriptExecHook(L); err != nil {
-			return nil, err
+	// Now we can execute the app entrypoint `app.lua` (or the matched page), wrapped by the middlewares
+	if err := a.chain(func(r *http.Request, L *lua.LState, resp *Response) error {
+		if err := doFile(L, a.fsys, entrypoint, a.chunkName(entrypoint)); err != nil {
+			// TODO(tsileo): display a nice stack trace in debug mode
+			return err
+		}
+
+		if a.conf.AfterScriptExecHook != nil {
+			if err := a.conf.AfterScriptExecHook(L); err != nil {
+				return err
+			}
 		}
+
+		// Make the output available to the middlewares
+		resp.flush()
+		return nil
+	})(r, L, resp); err != nil {
+		return nil, err
 	}
 
+	resp.luaTime = time.Since(luaStart)
 	return resp, nil
 }
 
+// Routes loads the app and returns the routes registered with the router (the request is not dispatched).
+func (a *App) Routes() ([]*Route, error) {
+	// In pages mode, the routes are already known
+	if a.pagesIndex != nil {
+		return a.pagesIndex.exportRoutes(), nil
+	}
+
+	r, err := http.NewRequest("GET", "/", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	w := &discardResponseWriter{}
+
+	// Initialize a Lua state
+	L := lua.NewState()
+	defer L.Close()
+
+	// Preload all the modules and setup global variables
+	resp, err := setupState(L, a.conf, a.stdlib(), w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the router module with one that only collects the routes
+	var routes []*Route
+	L.PreloadModule("router", setupRouter(resp, r.Method, r.URL.Path, a.fsys, func(rt *router) {
+		routes = append(routes, rt.exportRoutes()...)
+	}))
+
+	if err := doFile(L, a.fsys, a.appEntrypoint, a.chunkName(a.appEntrypoint)); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// Close releases the resources of the app (the `kv` database, the `sql` and `redis` connection pools, the zip archive).
+func (a *App) Close() error {
+	if err := releaseKV(kvPath(a.conf)); err != nil {
+		return err
+	}
+	if err := releaseDatabases(a.conf); err != nil {
+		return err
+	}
+	if a.conf.Redis != nil {
+		if err := a.conf.Redis.release(); err != nil {
+			return err
+		}
+	}
+	if a.archive != nil {
+		return a.archive.Close()
+	}
+	return nil
+}
+
 // ServeHTTP implements the `http.HandlerFunc` interface.
 func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	resp, err := a.Exec(w, r)
+	if a.conf.AccessLog == nil && a.conf.Metrics == nil {
+		if _, err := a.serveHTTP(w, r); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	// Record the response for the access log/metrics, and share the request ID with the `log` module
+	start := time.Now()
+	if a.conf.AccessLog != nil && r.Header.Get("X-Request-Id") == "" {
+		// Don't modify the request of the caller
+		r = r.Clone(r.Context())
+		r.Header.Set("X-Request-Id", requestID(r))
+	}
+	lw := &loggingResponseWriter{ResponseWriter: w}
+	resp, err := a.serveHTTP(lw, r)
+	if a.conf.Metrics != nil {
+		var route string
+		var luaTime time.Duration
+		if resp != nil {
+			route, luaTime = resp.route, resp.luaTime
+		}
+		status := lw.status
+		switch {
+		case err != nil:
+			status = http.StatusInternalServerError
+		case status == 0:
+			status = http.StatusOK
+		}
+		a.conf.Metrics.observeRequest(r.Method, route, status, err != nil, time.Since(start), luaTime)
+	}
+	// Errors are logged before being propagated
+	if a.conf.AccessLog != nil {
+		entry := newAccessLogEntry(r, lw, resp, start)
+		if err != nil {
+			entry.Status = http.StatusInternalServerError
+		}
+		a.writeAccessLog(entry)
+	}
 	if err != nil {
 		panic(err)
 	}
+}
+
+func (a *App) serveHTTP(w http.ResponseWriter, r *http.Request) (*Response, error) {
+	resp, err := a.Exec(w, r)
+	if err != nil {
+		return nil, err
+	}
 
 	if resp != nil {
 		// Write the request
 		resp.WriteTo(w)
 	}
+	return resp, nil
 }
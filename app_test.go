@@ -6,6 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis"
 )
 
 func TestApp(t *testing.T) {
@@ -41,6 +44,14 @@ func TestApp(t *testing.T) {
 			expectedResponseBody:       "bar",
 			expectedResponseStatusCode: 200,
 		},
+		// Ensure the routes can be listed from Lua
+		{
+			method:                     "GET",
+			server:                     server,
+			path:                       "/routes",
+			expectedResponseBody:       "GET /,GET /bar,GET /routes,POST /posts/:id,GET /assets_urls",
+			expectedResponseStatusCode: 200,
+		},
 		// Ensure files from public/ directory are served
 		{
 			method:                     "GET",
@@ -84,3 +95,163 @@ func TestApp(t *testing.T) {
 	}
 
 }
+
+func TestAppRoutes(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/app/"})
+	if err != nil {
+		panic(err)
+	}
+	routes, err := app.Routes()
+	if err != nil {
+		panic(err)
+	}
+	if len(routes) != 5 {
+		t.Fatalf("bad routes count, got %d, expected 5", len(routes))
+	}
+	if routes[1].Method != "GET" || routes[1].Path != "/bar" {
+		t.Errorf("bad route, got %s %s, expected GET /bar", routes[1].Method, routes[1].Path)
+	}
+	if routes[1].Meta["summary"] != "Bar page" {
+		t.Errorf("bad route meta, got %+v", routes[1].Meta)
+	}
+}
+
+func TestPagesApp(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/pages_app/", Pages: true})
+	if err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	testData := []struct {
+		path                       string
+		expectedResponseBody       string
+		expectedResponseStatusCode int
+	}{
+		{"/", "index", 200},
+		{"/about", "about", 200},
+		{"/blog", "blog index", 200},
+		{"/blog/new", "new post", 200},
+		{"/blog/hello-world", "post hello-world", 200},
+		{"/blog/hello/world", "Not Found", 404},
+		{"/blog/", "blog index", 200}, // Redirected to the canonical path
+		{"/blog//hello-world/", "post hello-world", 200},
+		{"/_lib/helpers", "Not Found", 404},
+	}
+
+	for _, tdata := range testData {
+		resp, err := http.Get(server.URL + tdata.path)
+		if err != nil {
+			panic(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if resp.StatusCode != tdata.expectedResponseStatusCode {
+			t.Errorf("%s: bad status code, got %d, expected %d", tdata.path, resp.StatusCode, tdata.expectedResponseStatusCode)
+		}
+		if string(body) != tdata.expectedResponseBody {
+			t.Errorf("%s: bad body, got %s, expected %s", tdata.path, body, tdata.expectedResponseBody)
+		}
+	}
+}
+
+func TestPagesPathPolicy(t *testing.T) {
+	testData := []struct {
+		policy, path             string
+		expectedStatusCode       int
+		expectedBody, expectedTo string
+	}{
+		{"", "/blog/", 301, "", "/blog"},
+		{"redirect", "/blog/?page=2", 301, "", "/blog?page=2"},
+		{"redirect", "/blog", 200, "blog index", ""},
+		{"lenient", "/blog/", 200, "blog index", ""},
+		{"lenient", "/blog//hello-world", 200, "post hello-world", ""},
+		{"strict", "/blog/", 404, "Not Found", ""},
+		{"strict", "/blog", 200, "blog index", ""},
+	}
+	for _, tdata := range testData {
+		app, err := NewApp(&Config{Path: "tests_data/pages_app/", Pages: true, PagesPathPolicy: tdata.policy})
+		if err != nil {
+			panic(err)
+		}
+		resp, err := app.Exec(httptest.NewRecorder(), httptest.NewRequest("GET", tdata.path, nil))
+		if err != nil {
+			panic(err)
+		}
+		resp.flush()
+		if resp.StatusCode != tdata.expectedStatusCode {
+			t.Errorf("%s %s: bad status code, got %d, expected %d", tdata.policy, tdata.path, resp.StatusCode,
+				tdata.expectedStatusCode)
+		}
+		if string(resp.Body) != tdata.expectedBody || resp.redirect != tdata.expectedTo {
+			t.Errorf("%s %s: bad response, got %q/%q, expected %q/%q", tdata.policy, tdata.path, resp.Body,
+				resp.redirect, tdata.expectedBody, tdata.expectedTo)
+		}
+		app.Close()
+	}
+
+	if _, err := NewApp(&Config{Path: "tests_data/pages_app/", Pages: true, PagesPathPolicy: "nope"}); err == nil {
+		t.Errorf("an unknown path policy should fail")
+	}
+}
+
+func TestAppSharedPools(t *testing.T) {
+	db, mock, err := sqlmock.NewWithDSN("gluapp_shared_pools")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	mock.ExpectClose()
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	// The apps of a `Mux` share the databases and the Redis config of the base config
+	base := &Config{
+		Path:      "tests_data/app/",
+		Databases: map[string]*Database{"main": {Driver: "sqlmock", DSN: "gluapp_shared_pools"}},
+		Redis:     &RedisConfig{Addr: s.Addr()},
+	}
+	var apps []*App
+	for i := 0; i < 2; i++ {
+		conf := &Config{}
+		*conf = *base
+		app, err := NewApp(conf)
+		if err != nil {
+			panic(err)
+		}
+		apps = append(apps, app)
+	}
+	sqlDB, err := base.Databases["main"].open()
+	if err != nil {
+		panic(err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		panic(err)
+	}
+	base.Redis.getPool()
+
+	// The pools stay open until the last app is closed
+	if err := apps[0].Close(); err != nil {
+		panic(err)
+	}
+	if base.Databases["main"].db == nil || base.Redis.pool == nil {
+		t.Errorf("the pools should still be open")
+	}
+	if err := apps[1].Close(); err != nil {
+		panic(err)
+	}
+	if base.Databases["main"].db != nil || base.Redis.pool != nil {
+		t.Errorf("the pools should be closed")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package gluapp
+
+import (
+	"path"
+	"strings"
+)
+
+// Length of the content hash embedded in the fingerprinted paths
+const fingerprintLen = 8
+
+// Cache-Control header value for the fingerprinted assets
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// assets maps the public/ files to their fingerprinted URL paths (`/css/site.css` => `/css/site.3f9a1c2b.css`), the
+// content hash is computed when the public index is built.
+type assets struct {
+	urls  map[string]string // original path => fingerprinted path
+	files map[string]string // fingerprinted path => original path
+}
+
+// fingerprintPath inserts the hash in the path, just before the extension
+func fingerprintPath(p, hash string) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + hash + ext
+}
+
+func newAssets(publicIndex map[string]*publicFile) *assets {
+	as := &assets{
+		urls:  map[string]string{},
+		files: map[string]string{},
+	}
+	for p, f := range publicIndex {
+		// No need to fingerprint the precompressed versions, they're served along with the original file
+		if ext := path.Ext(p); ext == ".gz" || ext == ".br" {
+			continue
+		}
+		fp := fingerprintPath(p, f.hash[:fingerprintLen])
+		as.urls[p] = fp
+		as.files[fp] = p
+	}
+	return as
+}
+
+// url returns the fingerprinted URL path for the given public/ file, the path is returned as is if the file does
+// not exist.
+func (as *assets) url(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if as == nil {
+		return p
+	}
+	if fp, ok := as.urls[p]; ok {
+		return fp
+	}
+	return p
+}
+
+// file returns the original path for the given fingerprinted path
+func (as *assets) file(fp string) (string, bool) {
+	if as == nil {
+		return "", false
+	}
+	p, ok := as.files[fp]
+	return p, ok
+}
@@ -0,0 +1,233 @@
+package gluapp
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"a4.io/blobstash/pkg/apps/luautil"
+	"github.com/yuin/gopher-lua"
+)
+
+// Default max size of the cache created by `NewApp`
+const defaultCacheMaxSize = 16 << 20
+
+// Cache is an in-memory LRU cache shared by the requests, exposed as the `cache` module:
+//
+//	local cache = require('cache')
+//	cache.set('key', {a = 1}, 60) -- expires in 60 seconds
+//	local v = cache.get('key')
+//	local resp = cache.get_or_set('weather', 300, function()
+//	  -- only one request at a time will execute the function for a given key
+//	  return fetch_weather()
+//	end)
+//
+// The requests waiting for another one to compute a value give up when they're canceled, and calling `get_or_set` for
+// a key from the function computing its value raises an error (instead of waiting for itself forever).
+//
+// The values are stored as JSON, the size of the cache is the size of the keys and the JSON values.
+type Cache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List // Most recently used entries first
+	items   map[string]*list.Element
+	calls   map[string]*cacheCall // In-flight `get_or_set` calls
+}
+
+type cacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // Zero if the entry never expires
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// cacheCall represents an in-flight `get_or_set` call, other callers for the same key wait for its result
+type cacheCall struct {
+	done  chan struct{} // Closed once the value is computed
+	owner interface{}   // Identifies the caller computing the value
+	value []byte
+	err   error
+}
+
+// NewCache returns a cache holding up to `maxSize` bytes.
+func NewCache(maxSize int64) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+		calls:   map[string]*cacheCall{},
+	}
+}
+
+// Get returns the JSON encoded value for the key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(key)
+}
+
+func (c *Cache) get(key string) ([]byte, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set sets the JSON encoded value for the key, it will expire after `ttl` if not 0.
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, ttl)
+}
+
+func (c *Cache) set(key string, value []byte, ttl time.Duration) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	entry := &cacheEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	// Don't evict everything for an entry that won't fit anyway
+	if entry.size() > c.maxSize {
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.size += entry.size()
+
+	// Evict the least recently used entries
+	for c.size > c.maxSize {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Delete removes the key from the cache.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size()
+}
+
+// getOrSet returns the value for the key, calling `fn` to compute it if needed, concurrent calls for the same key
+// wait for the result of the first one (or for `ctx` to be done).
+//
+// `owner` identifies the caller, a call for a key whose value is being computed by the same owner fails.
+func (c *Cache) getOrSet(ctx context.Context, owner interface{}, key string, ttl time.Duration, fn func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if value, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		if call.owner == owner {
+			return nil, fmt.Errorf("the value of %q is already being computed by the caller", key)
+		}
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &cacheCall{done: make(chan struct{}), owner: owner}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	completed := false
+	defer func() {
+		// `fn` panicked (e.g. a Lua error raised while converting the value), the waiters get an error
+		r := recover()
+		if !completed {
+			call.value, call.err = nil, fmt.Errorf("panic while computing the value: %v", r)
+		}
+		c.mu.Lock()
+		if call.err == nil {
+			c.set(key, call.value, ttl)
+		}
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+		if !completed {
+			panic(r)
+		}
+	}()
+	call.value, call.err = fn()
+	completed = true
+	return call.value, call.err
+}
+
+// cacheTTL returns the TTL given as argument (in seconds)
+func cacheTTL(L *lua.LState, n int) time.Duration {
+	return time.Duration(float64(L.OptNumber(n, 0)) * float64(time.Second))
+}
+
+// setupCache returns the loader of the `cache` module, `ctx` is the request context (waiting for a value computed by
+// another request stops when it's done).
+func setupCache(ctx context.Context, c *Cache) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		mod := L.NewTable()
+		L.SetFuncs(mod, map[string]lua.LGFunction{
+			"get": func(L *lua.LState) int {
+				value, ok := c.Get(L.CheckString(1))
+				if !ok {
+					L.Push(lua.LNil)
+					return 1
+				}
+				L.Push(luautil.FromJSON(L, value))
+				return 1
+			},
+			"set": func(L *lua.LState) int {
+				c.Set(L.CheckString(1), luautil.ToJSON(L, L.CheckAny(2)), cacheTTL(L, 3))
+				return 0
+			},
+			"delete": func(L *lua.LState) int {
+				c.Delete(L.CheckString(1))
+				return 0
+			},
+			"get_or_set": func(L *lua.LState) int {
+				key := L.CheckString(1)
+				ttl := cacheTTL(L, 2)
+				fn := L.CheckFunction(3)
+				// The module table identifies the Lua state (coroutines included) computing the value
+				value, err := c.getOrSet(ctx, mod, key, ttl, func() ([]byte, error) {
+					L.Push(fn)
+					if err := L.PCall(0, 1, nil); err != nil {
+						return nil, err
+					}
+					value := luautil.ToJSON(L, L.Get(-1))
+					L.Pop(1)
+					return value, nil
+				})
+				if err != nil {
+					L.RaiseError("get_or_set failed: %v", err)
+				}
+				L.Push(luautil.FromJSON(L, value))
+				return 1
+			},
+		})
+		L.Push(mod)
+		return 1
+	}
+}
@@ -0,0 +1,164 @@
+package gluapp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestCacheLRU(t *testing.T) {
+	c := NewCache(20)
+	c.Set("a", []byte("12345"), 0) // 6 bytes
+	c.Set("b", []byte("12345"), 0)
+	c.Set("c", []byte("12345"), 0)
+	// "a" is now the most recently used
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("a should be cached")
+	}
+	c.Set("d", []byte("12345"), 0)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b should have been evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("%s should be cached", k)
+		}
+	}
+	// Too big to fit
+	c.Set("e", make([]byte, 30), 0)
+	if _, ok := c.Get("e"); ok {
+		t.Errorf("e should not be cached")
+	}
+	c.Set("f", []byte("1"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("f"); ok {
+		t.Errorf("f should have expired")
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("a should have been deleted")
+	}
+}
+
+func TestCacheModule(t *testing.T) {
+	conf := &Config{Cache: NewCache(1 << 20)}
+	var calls int32
+
+	newState := func() *lua.LState {
+		L := lua.NewState()
+		if err := NewStdlib(conf).With("cache").Install(L); err != nil {
+			panic(err)
+		}
+		L.SetGlobal("fetch", L.NewFunction(func(L *lua.LState) int {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(20 * time.Millisecond)
+			L.Push(lua.LString("fetched"))
+			return 1
+		}))
+		return L
+	}
+
+	L := newState()
+	if err := L.DoString(`
+local cache = require('cache')
+assert(cache.get('k') == nil)
+cache.set('k', {a = {1, 2}})
+assert(cache.get('k').a[2] == 2)
+cache.delete('k')
+assert(cache.get('k') == nil)
+local ok, err = pcall(cache.get_or_set, 'fail', 0, function() error('oops') end)
+assert(not ok and string.find(tostring(err), 'oops'))
+assert(cache.get('fail') == nil)
+-- Computing a value needing itself fails instead of waiting forever
+ok, err = pcall(cache.get_or_set, 'loop', 0, function()
+  return cache.get_or_set('loop', 0, function() return 1 end)
+end)
+assert(not ok and string.find(tostring(err), 'already being computed'), tostring(err))
+ok, err = pcall(cache.get_or_set, 'co', 0, function()
+  return coroutine.wrap(function() return cache.get_or_set('co', 0, function() return 1 end) end)()
+end)
+assert(not ok and string.find(tostring(err), 'already being computed'), tostring(err))
+assert(cache.get_or_set('loop', 0, function() return 2 end) == 2)
+`); err != nil {
+		t.Errorf("failed to execute the script: %v", err)
+	}
+	L.Close()
+
+	// Concurrent calls should only fetch the value once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			L := newState()
+			defer L.Close()
+			if err := L.DoString(`assert(require('cache').get_or_set('weather', 60, fetch) == 'fetched')`); err != nil {
+				t.Errorf("failed to execute the script: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("the value should have been fetched once, got %d calls", calls)
+	}
+}
+
+func TestCacheGetOrSetPanic(t *testing.T) {
+	c := NewCache(1 << 20)
+	started := make(chan struct{})
+	waiterErr := make(chan error)
+	go func() {
+		<-started
+		_, err := c.getOrSet(context.Background(), "waiter", "k", 0, func() ([]byte, error) {
+			return []byte("unexpected"), nil
+		})
+		waiterErr <- err
+	}()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "oops" {
+				t.Errorf("the panic should be propagated, got %v", r)
+			}
+		}()
+		c.getOrSet(context.Background(), "computer", "k", 0, func() ([]byte, error) {
+			close(started)
+			// Let the other call wait for this one
+			time.Sleep(20 * time.Millisecond)
+			panic("oops")
+		})
+	}()
+
+	if err := <-waiterErr; err == nil {
+		t.Errorf("the waiting call should get an error")
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Errorf("nothing should be cached")
+	}
+}
+
+func TestCacheGetOrSetCanceled(t *testing.T) {
+	c := NewCache(1 << 20)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go c.getOrSet(context.Background(), "computer", "k", 0, func() ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("1"), nil
+	})
+	defer close(release)
+	<-started
+
+	// The waiting call gives up once its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.getOrSet(ctx, "waiter", "k", 0, func() ([]byte, error) {
+		return []byte("unexpected"), nil
+	}); err != context.DeadlineExceeded {
+		t.Errorf("the waiting call should be canceled, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package gluapp
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestCmd(t *testing.T) {
+	testData := []struct {
+		code        string
+		expectedErr string
+	}{
+		// Quoted arguments are kept as is
+		{`local res = cmd.run({'echo', 'hello  world'})
+assert(res.stdout == 'hello  world\n' and res.stderr == '' and res.exit_code == 0)
+assert(res.duration >= 0)`, ""},
+		{`local res = cmd.run({'sh', '-c', 'echo oops >&2; exit 3'})
+assert(res.stdout == '' and res.stderr == 'oops\n' and res.exit_code == 3)`, ""},
+		// Options
+		{`local res = cmd.run({'sh', '-c', 'pwd; echo $GREETING; cat'}, {dir = 'scripts', env = {GREETING = 'hi'}, stdin = 'in'})
+local lines = {}
+for line in res.stdout:gmatch('[^\n]+') do table.insert(lines, line) end
+assert(lines[1]:sub(-#'tests_data/scripts') == 'tests_data/scripts', lines[1])
+assert(lines[2] == 'hi' and lines[3] == 'in')`, ""},
+		// Streaming
+		{`local lines = {}
+local res = cmd.run({'sh', '-c', 'echo a; echo b >&2; echo c'}, {on_line = function(line, stream)
+  table.insert(lines, stream .. ':' .. line)
+end})
+assert(#lines == 3 and res.stdout == 'a\nc\n' and res.stderr == 'b\n')
+table.sort(lines)
+assert(lines[1] == 'stderr:b' and lines[2] == 'stdout:a' and lines[3] == 'stdout:c')`, ""},
+		{`cmd.run({'sh', '-c', 'echo a; sleep 10'}, {on_line = function(line) error('stop at ' .. line) end})`, "stop at a"},
+		{`cmd.run({'sleep', '10'}, {timeout = 0.05})`, "timed out"},
+		// The child processes keep the output open
+		{`cmd.run({'sh', '-c', 'sleep 10; echo done'}, {timeout = 0.05})`, "timed out"},
+		{`cmd.run({'gluapp-does-not-exist'})`, "failed to run"},
+		{`cmd.run({})`, "the command is empty"},
+		{`cmd.run({'pwd'}, {dir = '..'})`, "path outside of the root directory"},
+		{`cmd.run({'pwd'}, {dir = '/tmp'})`, "path outside of the root directory"},
+	}
+
+	for _, tdata := range testData {
+		L := lua.NewState()
+		if err := NewStdlib(&Config{Path: "tests_data"}).With("cmd").Install(L); err != nil {
+			panic(err)
+		}
+		start := time.Now()
+		err := L.DoString("local cmd = require('cmd')\n" + tdata.code)
+		switch {
+		case tdata.expectedErr == "" && err != nil:
+			t.Errorf("failed to execute %q: %v", tdata.code, err)
+		case tdata.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tdata.expectedErr)):
+			t.Errorf("expected error %q for %q, got %v", tdata.expectedErr, tdata.code, err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("process not killed for %q, took %v", tdata.code, d)
+		}
+		L.Close()
+	}
+
+	// The process is killed when the request is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	L := lua.NewState()
+	defer L.Close()
+	s := NewStdlib(&Config{}).With("cmd").Request(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if err := s.Install(L); err != nil {
+		panic(err)
+	}
+	start := time.Now()
+	if err := L.DoString(`require('cmd').run({'sleep', '10'})`); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("bad error for a canceled request: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("process not killed with the request, took %v", d)
+	}
+}
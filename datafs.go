@@ -0,0 +1,287 @@
+package gluapp
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"a4.io/gluapp/util"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// The `fs` module gives read/write access to the files of the data/ directory (`Config.DataPath`), the paths are
+// relative to it and are rejected if they leave it.
+//
+// Writes are atomic (the content is written to a temporary file that is renamed) and every operation locks the file,
+// `fs.lock(path, fn)` can be used to hold the lock during a read-modify-write cycle. The locks are shared by all the
+// requests of the process (they're advisory, other processes are not aware of them).
+
+var (
+	errOutsideDataDir = errors.New("path outside of the data directory")
+	errDataDir        = errors.New("the data directory itself can't be removed or renamed")
+)
+
+// Locks of the data files, keyed by absolute path (an entry is removed once it's not used anymore)
+var dataLocks = struct {
+	sync.Mutex
+	locks map[string]*dataLock
+}{locks: map[string]*dataLock{}}
+
+type dataLock struct {
+	sync.RWMutex
+	refs int // Number of holders (and waiters)
+}
+
+// lockData locks the file (for writing if `write` is true) and returns the unlock function
+func lockData(p string, write bool) func() {
+	dataLocks.Lock()
+	l, ok := dataLocks.locks[p]
+	if !ok {
+		l = &dataLock{}
+		dataLocks.locks[p] = l
+	}
+	l.refs++
+	dataLocks.Unlock()
+
+	if write {
+		l.Lock()
+	} else {
+		l.RLock()
+	}
+	return func() {
+		if write {
+			l.Unlock()
+		} else {
+			l.RUnlock()
+		}
+		dataLocks.Lock()
+		defer dataLocks.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(dataLocks.locks, p)
+		}
+	}
+}
+
+// dataFS is the state of the `fs` module for a Lua state
+type dataFS struct {
+	root string
+	held map[string]bool // Locks held with `fs.lock` (as the locks are not reentrant)
+}
+
+// dataPath returns the directory used by the `fs` module
+func dataPath(conf *Config) string {
+	if conf.DataPath != "" {
+		return conf.DataPath
+	}
+	return filepath.Join(conf.Path, "data")
+}
+
+// resolve returns the absolute path of the file, or an error if it's not inside the root
+func (d *dataFS) resolve(name string) (string, error) {
+	p, err := util.Resolve(d.root, name)
+	if err == util.ErrOutsideRoot {
+		return "", errOutsideDataDir
+	}
+	return p, err
+}
+
+// lock locks the file (for writing if `write` is true) and returns the unlock function, it's a no-op if the lock is
+// already held with `fs.lock`
+func (d *dataFS) lock(p string, write bool) func() {
+	if d.held[p] {
+		return func() {}
+	}
+	return lockData(p, write)
+}
+
+// check resolves the path given as argument, or raises an error
+func (d *dataFS) check(L *lua.LState, n int) string {
+	p, err := d.resolve(L.CheckString(n))
+	if err != nil {
+		L.ArgError(n, err.Error())
+	}
+	return p
+}
+
+// checkFile is like check, but the root itself is rejected (for the operations that would remove it)
+func (d *dataFS) checkFile(L *lua.LState, n int) string {
+	p := d.check(L, n)
+	if root, err := d.resolve("."); err != nil || p == root {
+		L.ArgError(n, errDataDir.Error())
+	}
+	return p
+}
+
+// writeFileAtomic writes the file to a temporary file in the same directory and then renames it
+func writeFileAtomic(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func statTable(L *lua.LState, fi os.FileInfo) *lua.LTable {
+	tbl := L.CreateTable(0, 4)
+	tbl.RawSetString("name", lua.LString(fi.Name()))
+	tbl.RawSetString("size", lua.LNumber(fi.Size()))
+	tbl.RawSetString("is_dir", lua.LBool(fi.IsDir()))
+	tbl.RawSetString("mod_time", lua.LNumber(fi.ModTime().Unix()))
+	return tbl
+}
+
+func setupDataFS(root string) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		d := &dataFS{root: root, held: map[string]bool{}}
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"read": func(L *lua.LState) int {
+				p := d.check(L, 1)
+				defer d.lock(p, false)()
+				data, err := ioutil.ReadFile(p)
+				if err != nil {
+					L.RaiseError("failed to read: %v", err)
+				}
+				L.Push(lua.LString(data))
+				return 1
+			},
+			"write": func(L *lua.LState) int {
+				p := d.check(L, 1)
+				data := L.CheckString(2)
+				defer d.lock(p, true)()
+				if err := writeFileAtomic(p, []byte(data)); err != nil {
+					L.RaiseError("failed to write: %v", err)
+				}
+				return 0
+			},
+			"append": func(L *lua.LState) int {
+				p := d.check(L, 1)
+				data := L.CheckString(2)
+				defer d.lock(p, true)()
+				if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+					L.RaiseError("failed to append: %v", err)
+				}
+				f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+				if err != nil {
+					L.RaiseError("failed to append: %v", err)
+				}
+				defer f.Close()
+				if _, err := f.WriteString(data); err != nil {
+					L.RaiseError("failed to append: %v", err)
+				}
+				return 0
+			},
+			"list": func(L *lua.LState) int {
+				p := d.root
+				if L.GetTop() > 0 && L.Get(1) != lua.LNil {
+					p = d.check(L, 1)
+				}
+				defer d.lock(p, false)()
+				infos, err := ioutil.ReadDir(p)
+				if err != nil {
+					L.RaiseError("failed to list: %v", err)
+				}
+				sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+				tbl := L.CreateTable(len(infos), 0)
+				for _, fi := range infos {
+					tbl.Append(statTable(L, fi))
+				}
+				L.Push(tbl)
+				return 1
+			},
+			"stat": func(L *lua.LState) int {
+				p := d.check(L, 1)
+				defer d.lock(p, false)()
+				fi, err := os.Stat(p)
+				switch {
+				case err == nil:
+					L.Push(statTable(L, fi))
+				case os.IsNotExist(err):
+					L.Push(lua.LNil)
+				default:
+					L.RaiseError("failed to stat: %v", err)
+				}
+				return 1
+			},
+			"mkdir": func(L *lua.LState) int {
+				p := d.check(L, 1)
+				if err := os.MkdirAll(p, 0700); err != nil {
+					L.RaiseError("failed to mkdir: %v", err)
+				}
+				return 0
+			},
+			"remove": func(L *lua.LState) int {
+				p := d.checkFile(L, 1)
+				defer d.lock(p, true)()
+				remove := os.Remove
+				if L.OptBool(2, false) {
+					remove = os.RemoveAll
+				}
+				if err := remove(p); err != nil {
+					L.RaiseError("failed to remove: %v", err)
+				}
+				return 0
+			},
+			"rename": func(L *lua.LState) int {
+				src, dst := d.checkFile(L, 1), d.checkFile(L, 2)
+				// Lock the files in the same order to prevent deadlocks
+				first, second := src, dst
+				if second < first {
+					first, second = second, first
+				}
+				defer d.lock(first, true)()
+				if second != first {
+					defer d.lock(second, true)()
+				}
+				if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+					L.RaiseError("failed to rename: %v", err)
+				}
+				if err := os.Rename(src, dst); err != nil {
+					L.RaiseError("failed to rename: %v", err)
+				}
+				return 0
+			},
+			"lock": func(L *lua.LState) int {
+				p := d.check(L, 1)
+				fn := L.CheckFunction(2)
+				if d.held[p] {
+					L.RaiseError("lock already held for %s", L.ToString(1))
+				}
+				unlock := d.lock(p, true)
+				d.held[p] = true
+				defer func() {
+					delete(d.held, p)
+					unlock()
+				}()
+				top := L.GetTop()
+				L.Push(fn)
+				L.Call(0, lua.MultRet)
+				return L.GetTop() - top
+			},
+		})
+		L.Push(mod)
+		return 1
+	}
+}
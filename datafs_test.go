@@ -0,0 +1,127 @@
+package gluapp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestDataFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gluapp_datafs")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &Config{DataPath: filepath.Join(dir, "data")}
+
+	// Create a symlink pointing outside of the data directory
+	if err := os.MkdirAll(conf.DataPath, 0700); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(conf.DataPath, "escape")); err != nil {
+		panic(err)
+	}
+
+	testData := []struct {
+		code        string
+		expectedErr string
+	}{
+		{`
+local fs = require('fs')
+fs.write('notes/a.txt', 'hello')
+fs.append('notes/a.txt', ' world')
+assert(fs.read('notes/a.txt') == 'hello world')
+local st = fs.stat('notes/a.txt')
+assert(st.size == 11 and not st.is_dir)
+assert(fs.stat('notes/nope') == nil)
+fs.rename('notes/a.txt', 'notes/b.txt')
+fs.mkdir('notes/sub')
+local names = {}
+for _, e in ipairs(fs.list('notes')) do table.insert(names, e.name) end
+assert(table.concat(names, ',') == 'b.txt,sub', table.concat(names, ','))
+fs.remove('notes', true)
+assert(fs.stat('notes') == nil)
+`, ""},
+		// The lock is held during the callback, and the operations on the same file don't deadlock
+		{`
+local fs = require('fs')
+local ret = fs.lock('counter', function()
+  fs.write('counter', 'locked')
+  return fs.read('counter')
+end)
+assert(ret == 'locked')
+`, ""},
+		{`require('fs').read('../secret')`, "path outside of the data directory"},
+		{`require('fs').read('/etc/passwd')`, "path outside of the data directory"},
+		{`require('fs').write('a/../../secret', 'x')`, "path outside of the data directory"},
+		{`require('fs').write('escape/secret', 'x')`, "path outside of the data directory"},
+		{`require('fs').read('nope')`, "failed to read"},
+		{`require('fs').remove('.', true)`, "the data directory itself can't be removed"},
+		{`require('fs').remove('a/..', true)`, "the data directory itself can't be removed"},
+		{`require('fs').remove('', true)`, "path outside of the data directory"},
+		{`require('fs').rename('.', 'moved')`, "the data directory itself can't be removed or renamed"},
+	}
+
+	for _, tdata := range testData {
+		L := lua.NewState()
+		if err := NewStdlib(conf).With("fs").Install(L); err != nil {
+			panic(err)
+		}
+		err := L.DoString(tdata.code)
+		L.Close()
+		switch {
+		case tdata.expectedErr == "" && err != nil:
+			t.Errorf("unexpected error for %q: %v", tdata.code, err)
+		case tdata.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tdata.expectedErr)):
+			t.Errorf("bad error for %q, got %v, expected %q", tdata.code, err, tdata.expectedErr)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secret")); !os.IsNotExist(err) {
+		t.Errorf("a file has been written outside of the data directory")
+	}
+	if _, err := os.Stat(filepath.Join(conf.DataPath, "counter")); err != nil {
+		t.Errorf("the data directory has been removed: %v", err)
+	}
+
+	// Concurrent read-modify-write cycles from multiple Lua states
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			L := lua.NewState()
+			defer L.Close()
+			if err := NewStdlib(conf).With("fs").Install(L); err != nil {
+				panic(err)
+			}
+			if err := L.DoString(`
+local fs = require('fs')
+fs.lock('count', function()
+  local n = 0
+  if fs.stat('count') then n = tonumber(fs.read('count')) end
+  fs.write('count', tostring(n + 1))
+end)
+`); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	wg.Wait()
+	data, err := ioutil.ReadFile(filepath.Join(conf.DataPath, "count"))
+	if err != nil {
+		panic(err)
+	}
+	if string(data) != "10" {
+		t.Errorf("bad count, got %s, expected 10", data)
+	}
+	dataLocks.Lock()
+	defer dataLocks.Unlock()
+	if n := len(dataLocks.locks); n != 0 {
+		t.Errorf("the released locks should be removed, got %d", n)
+	}
+}
@@ -0,0 +1,89 @@
+package gluapp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Apps can be loaded from any `fs.FS` (like an `embed.FS` or a `*zip.Reader`) instead of a directory, in this case
+// every file (Lua modules, templates, public/ assets...) is read from the filesystem.
+
+// doFile executes the given Lua file from the filesystem (like `L.DoFile`)
+func doFile(L *lua.LState, fsys fs.FS, name, chunkName string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	fn, err := L.Load(bytes.NewReader(data), chunkName)
+	if err != nil {
+		return err
+	}
+	L.Push(fn)
+	return L.PCall(0, lua.MultRet, nil)
+}
+
+// setupFSLoader registers a `require` loader that looks up the Lua modules in the filesystem (`require('lib.utils')`
+// will load `lib/utils.lua`), it's inserted just after the preload loader.
+func setupFSLoader(L *lua.LState, fsys fs.FS) {
+	loader := L.NewFunction(func(L *lua.LState) int {
+		name := strings.Replace(L.CheckString(1), ".", "/", -1) + ".lua"
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			L.Push(lua.LString(fmt.Sprintf("\n\tno file '%s' in the app filesystem", name)))
+			return 1
+		}
+		fn, err := L.Load(bytes.NewReader(data), name)
+		if err != nil {
+			L.RaiseError(err.Error())
+		}
+		L.Push(fn)
+		return 1
+	})
+	loaders, ok := L.GetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "loaders").(*lua.LTable)
+	if !ok {
+		return
+	}
+	loaders.Insert(2, loader)
+}
+
+// readSeekCloser is returned by `openSeeker`
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openSeeker opens the file and returns a seekable reader, files that can't seek (e.g. from a zip archive) are read
+// in memory.
+func openSeeker(fsys fs.FS, name string) (readSeekCloser, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rsc, ok := f.(readSeekCloser); ok {
+		return rsc, nil
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// fsPath converts a path provided by a Lua script to a valid `fs.FS` path
+func fsPath(p string) string {
+	return path.Clean(strings.TrimPrefix(strings.Replace(p, "\\", "/", -1), "/"))
+}
@@ -0,0 +1,126 @@
+package gluapp
+
+import (
+	"archive/zip"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+var testFSApp = fstest.MapFS{
+	"app.lua": &fstest.MapFile{Data: []byte(`
+local hello = require('lib.hello')
+local tpl = require('template')
+local util = require('util')
+router = require('router').new()
+
+router:get('/', function()
+  app.response:write(hello.hello('fs') .. ' ' .. util.read_file('data.txt'))
+end)
+
+router:get('/tpl', function()
+  app.response:write(tpl.render('hello.html', {world = 'FS'}))
+end)
+
+router:run()
+`)},
+	"lib/hello.lua":        &fstest.MapFile{Data: []byte("return {hello = function(n) return 'hello ' .. n end}\n")},
+	"data.txt":             &fstest.MapFile{Data: []byte("data")},
+	"templates/hello.html": &fstest.MapFile{Data: []byte("Hello {{ .world }}!")},
+	"public/style.css":     &fstest.MapFile{Data: []byte("body{}")},
+}
+
+// zipFS writes the filesystem to a zip archive
+func zipFS(fsys fs.FS, dst string) {
+	f, err := os.Create(dst)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	if err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(p)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}); err != nil {
+		panic(err)
+	}
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+}
+
+func TestFSApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gluapp_fs")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	zipPath := filepath.Join(dir, "app.zip")
+	zipFS(testFSApp, zipPath)
+
+	for _, conf := range []*Config{
+		{FS: testFSApp},
+		{Path: zipPath},
+	} {
+		app, err := NewApp(conf)
+		if err != nil {
+			panic(err)
+		}
+
+		server := httptest.NewServer(app)
+
+		testData := []struct {
+			path                       string
+			expectedResponseBody       string
+			expectedResponseStatusCode int
+		}{
+			{"/", "hello fs data", 200},
+			{"/tpl", "Hello FS!", 200},
+			{"/style.css", "body{}", 200},
+			{"/nope", "Not Found", 404},
+		}
+
+		for _, tdata := range testData {
+			resp, err := http.Get(server.URL + tdata.path)
+			if err != nil {
+				panic(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				panic(err)
+			}
+			if resp.StatusCode != tdata.expectedResponseStatusCode {
+				t.Errorf("%s: bad status code, got %d, expected %d", tdata.path, resp.StatusCode, tdata.expectedResponseStatusCode)
+			}
+			if string(body) != tdata.expectedResponseBody {
+				t.Errorf("%s: bad body, got %q, expected %q", tdata.path, body, tdata.expectedResponseBody)
+			}
+		}
+
+		server.Close()
+		if err := app.Close(); err != nil {
+			panic(err)
+		}
+		// The zip archive is closed with the app
+		if _, err := fs.ReadFile(app.fsys, "app.lua"); conf.Path == zipPath && err == nil {
+			t.Errorf("the zip archive should be closed")
+		}
+	}
+}
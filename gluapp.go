@@ -1,25 +1,22 @@
 package gluapp // import "a4.io/gluapp"
 
 import (
+	"context"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"net/http"
-	"path/filepath"
-
-	"a4.io/blobstash/pkg/apps/luautil"
-	"a4.io/gluapp/util"
-	"a4.io/gluarequire2"
+	"os"
 
 	"github.com/yuin/gopher-lua"
 )
 
-// TODO(tsileo): a logFunc(t time.Time, msg string, args ...interface{})?
-// TODO(tsileo): an error sink ; improved error/logging/stats handling
+// TODO(tsileo): an error sink
 // XXX(tsileo): unit testing support (for user, as lua script with a custom CLI for running tests)
 // XXX(tsileo): cookies support?
 // XXX(tsileo): a middleware method for the router?
 // XXX(tsileo): a tiny package manager based on github?
-// XXX(tsileo): log to a different file?
 
 var methods = []string{
 	"GET", "POST", "PUT", "PATCH", "DELETE", "TRACE", "CONNECT", "OPTIONS", "HEAD",
@@ -30,25 +27,100 @@ type Config struct {
 	// Path for looking up resources (Lua files, templates, public assets)
 	Path string
 
+	// Filesystem to load the app from instead of `Path` (e.g. an `embed.FS` or a `*zip.Reader`), the Lua modules,
+	// templates, files read by `util` and public/ assets are read from it (`Path` can also point to a `.zip` file)
+	FS fs.FS
+
 	// Define the app entrypoint, default to `app.lua` (only valid for apps)
 	Entrypoint string
 
+	// Enable the file-based routing mode, files in `pages/` are mapped to URL paths (`pages/blog/[slug].lua` will
+	// handle `/blog/:slug`) and the entrypoint is not used (only valid for apps)
+	Pages bool
+
+	// Path normalization policy of the pages mode (`strict`, `redirect` or `lenient`, like the `path_policy` option of
+	// `router.new`), default to `redirect` (`/blog/` is redirected to `/blog`)
+	PagesPathPolicy string
+
 	// HTTP client, if not set, `http.DefaultClient` will be used
 	Client *http.Client
 
+	// Directory used by the `fs` module, default to `<Path>/data` (should be set for apps loaded from `FS`/a zip)
+	DataPath string
+
+	// Path of the database used by the `kv` module, default to `<DataPath>/kv.db`
+	KVPath string
+
+	// Cache shared by the requests (the `cache` module), `NewApp` creates a 16MB one if not set
+	Cache *Cache
+
+	// Databases available in the `sql` module, by name
+	Databases map[string]*Database
+
+	// Redis server used by the `redis` module
+	Redis *RedisConfig
+
 	// Hook for adding/setting additional modules/global variables
 	SetupState func(L *lua.LState, w http.ResponseWriter, r *http.Request) error
 
+	// Values exposed to Lua as `app.context` (e.g. the user authenticated by a Go middleware), called for each
+	// request with the request context, the values are converted like the JSON ones
+	ContextToLua func(ctx context.Context) map[string]interface{}
+
+	// Restrict what the Lua code can do (e.g. executing processes or reading files), everything is allowed if nil
+	Capabilities *Capabilities
+
 	// Hook executed just after the script execution, just before the request is written
 	AfterScriptExecHook func(L *lua.LState) error
 
-	// Hook for custom `log` backend, defautl to `fmt.Println`
+	// Receives the entries logged with the `log` module, default to JSON lines written to stdout
+	Logger Logger
+
+	// Hook for the legacy `log(format, ...)` calls, sent to `Logger` if not set
 	LogHook func(logLine string) error
 
+	// Access log of the requests served by `App.ServeHTTP`, disabled if nil (a `X-Request-Id` header is set on the
+	// requests without one, to match the `log` entries)
+	AccessLog io.Writer
+
+	// Format of the access log, `AccessLogCommon` (the default), `AccessLogCombined` or `AccessLogJSON`
+	AccessLogFormat string
+
+	// Collect metrics for the requests served by `App.ServeHTTP` and the `http` module calls, disabled if nil
+	Metrics *Metrics
+
 	// Stack trace will be displayed in debug mode
 	Debug bool
 
 	TemplateFuncMap template.FuncMap
+
+	// If set, the OpenAPI document generated from the router routes will be served at this path (only valid for apps)
+	OpenAPIPath string
+
+	// `Cache-Control` header values for files served from public/, by extension (e.g. ".css"), the "" key is used
+	// as a default
+	PublicCacheControl map[string]string
+
+	// Compress (gzip) the files served from public/ on the fly if they're compressible and at least this size (in
+	// bytes), disabled if 0 (precompressed `.gz`/`.br` files are always served if the client accepts them)
+	PublicGzipMinSize int64
+
+	// Minify the CSS/JS/SVG/HTML files served from public/ (the result is cached in memory)
+	Minify bool
+
+	// Minify the HTML rendered by the `template` module
+	MinifyTemplates bool
+
+	// Additional directories (relative to `Path`, or absolute) served under the given URL prefixes (only valid for apps),
+	// the relative directories of the apps loaded from a zip archive or `FS` must be inside of it
+	StaticMounts map[string]string
+
+	// Enable the directory listing for the static mounts (when there's no `index.html` file)
+	StaticDirListing bool
+
+	// Title and version of the generated OpenAPI document
+	OpenAPITitle   string
+	OpenAPIVersion string
 }
 
 // Setup "global" metatable (used by multiple modules)
@@ -148,103 +220,38 @@ func setupMetatable(L *lua.LState) {
 	}))
 }
 
-func getFuncMaps(fm template.FuncMap) template.FuncMap {
+func getFuncMaps(fm template.FuncMap, as *assets, root string) template.FuncMap {
 	finalFuncs := template.FuncMap{}
 	for k, v := range funcs {
 		finalFuncs[k] = v
 	}
-	if fm != nil {
-		for k, v := range fm {
-			finalFuncs[k] = v
-		}
-	} else {
-		finalFuncs = funcs
+	finalFuncs["asset_url"] = func(p string) string {
+		return root + as.url(p)
+	}
+	for k, v := range fm {
+		finalFuncs[k] = v
 	}
 	return finalFuncs
 }
 
-func setupState(L *lua.LState, conf *Config, w http.ResponseWriter, r *http.Request) (*Response, error) {
-	// Update the path if needed
-	if conf.Path != "" {
-		path := L.GetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "path").(lua.LString)
-		path = lua.LString(conf.Path + "/?.lua;" + string(path))
-		L.SetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "path", lua.LString(path))
+// appFS returns the filesystem of the app, `conf.Path` is used if the app is not loaded from a filesystem
+func appFS(conf *Config, fsys fs.FS) fs.FS {
+	if fsys != nil {
+		return fsys
 	}
+	if conf.Path == "" {
+		return os.DirFS(".")
+	}
+	return os.DirFS(conf.Path)
+}
 
-	// Setup `require2`
-	gluarequire2.NewRequire2Module(gluarequire2.NewRequireFromGitHub(nil)).SetGlobal(L)
-
-	// Setup shared Lua metatables
-	setupMetatable(L)
-
-	// FIXME(tsileo): move this in a separate module, along with the "path specific" (like read_yaml" into a separate module so BlobStash can use it as a "stdlib"
-	util.Setup(L, conf.Path)
-	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
-		var args []lua.LValue
-		for i := 1; i <= L.GetTop(); i++ {
-			item := L.Get(i)
-			// We don't want table to be displayed as "table: 0xc420272240"
-			if t, ok := item.(*lua.LTable); ok {
-				item = lua.LString(luautil.ToJSON(L, t))
-			}
-			args = append(args, item)
-		}
-
-		// Call `string.format`
-		if err := L.CallByParam(lua.P{
-			Fn:      lua.LValue(L.GetField(L.GetGlobal("string"), "format").(*lua.LFunction)),
-			NRet:    1,
-			Protect: true,
-		}, args...); err != nil {
-			panic(err)
-		}
-
-		// Get the result
-		logLine := string(L.Get(-1).(lua.LString))
-		L.Pop(1)
-
-		// Execute the hook
-		if conf.LogHook == nil {
-			fmt.Println(logLine)
-		} else {
-			if err := conf.LogHook(logLine); err != nil {
-				panic(err)
-			}
-		}
-
-		return 0
-	}))
-
-	// Setup `request`
-	req, err := newRequest(L, r)
-	if err != nil {
+// setupState setups the Lua state for the request with the modules selected in `s`.
+func setupState(L *lua.LState, conf *Config, s *Stdlib, w http.ResponseWriter, r *http.Request) (*Response, error) {
+	// Preload all the modules and setup global variables
+	s.Request(w, r)
+	if err := s.Install(L); err != nil {
 		return nil, err
 	}
-	// Initialize `response`
-	resp, lresp := newResponse(L, w, r)
-
-	// Set the `app` global variable
-	rootTable := L.CreateTable(0, 2)
-	rootTable.RawSetH(lua.LString("request"), req)
-	rootTable.RawSetH(lua.LString("response"), resp)
-	L.SetGlobal("app", rootTable)
-
-	// Setup other modules
-	L.PreloadModule("router", setupRouter(lresp, r.Method, r.URL.Path))
-	L.PreloadModule("json", loadJSON)
-
-	client := conf.Client
-	if client == nil {
-		client = http.DefaultClient
-	}
-	L.PreloadModule("http", setupHTTP(client, conf.Path))
-
-	L.PreloadModule("url", setupURL())   // must be executed after setupHTTP
-	L.PreloadModule("form", setupForm()) // must be executed after setupHTTP
-	finalFuncs := getFuncMaps(conf.TemplateFuncMap)
-
-	L.PreloadModule("template", setupTemplate(filepath.Join(conf.Path, "templates"), finalFuncs))
-	// TODO(tsileo): a read/write file module for the data/ directory???
 
 	// Setup additional modules provided by the user
 	if conf.SetupState != nil {
@@ -253,77 +260,17 @@ func setupState(L *lua.LState, conf *Config, w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	return lresp, nil
+	return s.resp, nil
 }
 
-// SetupGlue setup the "glue"/std lib for use outside of gluapp
+// SetupGlue setup the "glue"/std lib for use outside of gluapp (use `NewStdlib` to only install some modules)
+//
+// `ReleaseGlue` must be called once the Lua state is closed, to close the `kv` database (it's kept open while an app
+// or another state is using it).
 func SetupGlue(L *lua.LState, conf *Config, w http.ResponseWriter, r *http.Request) error {
-	// Update the path if needed
-	if conf.Path != "" {
-		path := L.GetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "path").(lua.LString)
-		path = lua.LString(conf.Path + "/?.lua;" + string(path))
-		L.SetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "path", lua.LString(path))
-	}
-
-	// Setup `require2`
-	gluarequire2.NewRequire2Module(gluarequire2.NewRequireFromGitHub(nil)).SetGlobal(L)
-
-	// Setup shared Lua metatables
-	setupMetatable(L)
-
-	// FIXME(tsileo): move this in a separate module, along with the "path specific" (like read_yaml" into a separate module so BlobStash can use it as a "stdlib"
-	util.Setup(L, conf.Path)
-	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
-		var args []lua.LValue
-		for i := 1; i <= L.GetTop(); i++ {
-			item := L.Get(i)
-			// We don't want table to be displayed as "table: 0xc420272240"
-			if t, ok := item.(*lua.LTable); ok {
-				item = lua.LString(luautil.ToJSON(L, t))
-			}
-			args = append(args, item)
-		}
-
-		// Call `string.format`
-		if err := L.CallByParam(lua.P{
-			Fn:      lua.LValue(L.GetField(L.GetGlobal("string"), "format").(*lua.LFunction)),
-			NRet:    1,
-			Protect: true,
-		}, args...); err != nil {
-			panic(err)
-		}
-
-		// Get the result
-		logLine := string(L.Get(-1).(lua.LString))
-		L.Pop(1)
-
-		// Execute the hook
-		if conf.LogHook == nil {
-			fmt.Println(logLine)
-		} else {
-			if err := conf.LogHook(logLine); err != nil {
-				panic(err)
-			}
-		}
-
-		return 0
-	}))
-
-	// Setup other modules
-	L.PreloadModule("json", loadJSON)
-
-	client := conf.Client
-	if client == nil {
-		client = http.DefaultClient
+	if err := NewStdlib(conf).All().Install(L); err != nil {
+		return err
 	}
-	L.PreloadModule("http", setupHTTP(client, conf.Path))
-
-	L.PreloadModule("url", setupURL())   // must be executed after setupHTTP
-	L.PreloadModule("form", setupForm()) // must be executed after setupHTTP
-
-	finalFuncs := getFuncMaps(conf.TemplateFuncMap)
-	L.PreloadModule("template", setupTemplate(filepath.Join(conf.Path, "templates"), finalFuncs))
-	// TODO(tsileo): a read/write file module for the data/ directory???
 
 	// Setup additional modules provided by the user
 	if conf.SetupState != nil {
@@ -332,19 +279,30 @@ func SetupGlue(L *lua.LState, conf *Config, w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	return nil
+	return acquireKV(kvPath(conf))
+}
+
+// ReleaseGlue releases the resources used by a Lua state setup with `SetupGlue`.
+func ReleaseGlue(conf *Config) error {
+	return releaseKV(kvPath(conf))
 }
 
 // Exec run the code as a Lua script
 func Exec(conf *Config, code string, w http.ResponseWriter, r *http.Request) error {
 	// TODO(tsileo): clean error, take L as argument
 
+	// The `kv` database is closed once the script is done (unless an app is using it)
+	if err := acquireKV(kvPath(conf)); err != nil {
+		return err
+	}
+	defer releaseKV(kvPath(conf))
+
 	// Initialize a Lua state
 	L := lua.NewState()
 	defer L.Close()
 
 	// Preload all the modules and setup global variables
-	resp, err := setupState(L, conf, w, r)
+	resp, err := setupState(L, conf, NewStdlib(conf).All(), w, r)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,7 @@ package gluapp
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -53,6 +54,24 @@ end)
 router:run()
 `
 
+var testAppRouter2 = `
+router = require('router').new({path_policy = 'redirect'})
+router:get('/hello/:name', function(params)
+  app.response:write('hello ' .. params.name .. ' ' .. app.request:path())
+end)
+router:run()
+`
+
+var testAppContext = `
+if app.context.user then
+  app.response:write('hello ' .. app.context.user.name .. ' ' .. tostring(app.context.flags.beta))
+else
+  app.response:error(401)
+end
+`
+
+type testUserKey struct{}
+
 func TestExec(t *testing.T) {
 	h1 := func(w http.ResponseWriter, r *http.Request) {
 		if err := Exec(&Config{}, testApp1, w, r); err != nil {
@@ -66,9 +85,38 @@ func TestExec(t *testing.T) {
 		}
 	}
 
+	h3 := func(w http.ResponseWriter, r *http.Request) {
+		if err := Exec(&Config{}, testAppRouter2, w, r); err != nil {
+			panic(err)
+		}
+	}
+
+	h4 := func(w http.ResponseWriter, r *http.Request) {
+		// Simulate an authentication middleware
+		if user := r.URL.Query().Get("user"); user != "" {
+			r = r.WithContext(context.WithValue(r.Context(), testUserKey{}, user))
+		}
+		if err := Exec(&Config{
+			ContextToLua: func(ctx context.Context) map[string]interface{} {
+				user, ok := ctx.Value(testUserKey{}).(string)
+				if !ok {
+					return map[string]interface{}{}
+				}
+				return map[string]interface{}{
+					"user":  map[string]interface{}{"name": user},
+					"flags": map[string]interface{}{"beta": true},
+				}
+			},
+		}, testAppContext, w, r); err != nil {
+			panic(err)
+		}
+	}
+
 	servers := map[string]*httptest.Server{
 		"s1": httptest.NewServer(http.HandlerFunc(h1)),
 		"s2": httptest.NewServer(http.HandlerFunc(h2)),
+		"s3": httptest.NewServer(http.HandlerFunc(h3)),
+		"s4": httptest.NewServer(http.HandlerFunc(h4)),
 	}
 
 	for _, server := range servers {
@@ -105,6 +153,29 @@ func TestExec(t *testing.T) {
 			expectedResponseBody:       "hello thomas",
 			expectedResponseStatusCode: 200,
 		},
+		// Ensure the client is redirected to the canonical path
+		{
+			method:                     "GET",
+			server:                     servers["s3"],
+			path:                       "//hello/./thomas/",
+			expectedResponseBody:       "hello thomas /hello/thomas",
+			expectedResponseStatusCode: 200,
+		},
+		// Ensure the values from the request context are exposed
+		{
+			method:                     "GET",
+			server:                     servers["s4"],
+			path:                       "/?user=thomas",
+			expectedResponseBody:       "hello thomas true",
+			expectedResponseStatusCode: 200,
+		},
+		{
+			method:                     "GET",
+			server:                     servers["s4"],
+			path:                       "/",
+			expectedResponseBody:       "Unauthorized",
+			expectedResponseStatusCode: 401,
+		},
 	}
 
 	for _, tdata := range testData {
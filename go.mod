@@ -3,11 +3,16 @@ module a4.io/gluapp
 require (
 	a4.io/blobstash v0.0.0-20200311204339-04f83bc3d616
 	a4.io/gluarequire2 v0.0.0-20200222094423-7528d5a10bc1
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/alicebob/miniredis v2.5.0+incompatible
+	github.com/gomodule/redigo v1.8.4
 	github.com/yuin/goldmark v1.1.25
 	github.com/yuin/goldmark-highlighting v0.0.0-20200307114337-60d527fdb691
 	github.com/yuin/gopher-lua v0.0.0-20191220021717-ab39c6098bdb
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/yaml.v2 v2.2.8
 	mvdan.cc/xurls v1.1.0
 )
 
-go 1.13
+go 1.16
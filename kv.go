@@ -0,0 +1,364 @@
+package gluapp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"a4.io/blobstash/pkg/apps/luautil"
+	"github.com/yuin/gopher-lua"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The `kv` module is a key-value store backed by an embedded database (bbolt), the values are stored as JSON:
+//
+//	local kv = require('kv')
+//	local users = kv.bucket('users')
+//	users:put('thomas', {name = 'Thomas'})
+//	users:put('session:1', {user = 'thomas'}, 3600) -- expires in 1 hour
+//	local user = users:get('thomas')
+//	for key, value in users:iter('session:') do ... end
+//	kv.update(function()
+//	  -- every operation in the callback is done atomically
+//	end)
+//
+// The database is opened once per process, and shared by all the requests (and the apps using the same file).
+
+// Open databases, keyed by absolute path (bbolt does not allow opening the same file twice)
+var kvStores = struct {
+	sync.Mutex
+	stores map[string]*kvStore
+}{stores: map[string]*kvStore{}}
+
+type kvStore struct {
+	db   *bolt.DB // Opened on first use
+	refs int      // Number of apps using the database
+}
+
+// kvPath returns the path of the database used by the `kv` module
+func kvPath(conf *Config) string {
+	if conf.KVPath != "" {
+		return conf.KVPath
+	}
+	return filepath.Join(dataPath(conf), "kv.db")
+}
+
+// kvStoreFor returns the store for the given path, kvStores must be locked
+func kvStoreFor(p string) (*kvStore, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := kvStores.stores[p]
+	if !ok {
+		s = &kvStore{}
+		kvStores.stores[p] = s
+	}
+	return s, nil
+}
+
+// openKV returns the database for the given path, opening it if needed
+func openKV(p string) (*bolt.DB, error) {
+	kvStores.Lock()
+	defer kvStores.Unlock()
+	s, err := kvStoreFor(p)
+	if err != nil {
+		return nil, err
+	}
+	if s.db != nil {
+		return s.db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(p, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return db, nil
+}
+
+// acquireKV registers an app using the database for the given path (it's closed once every app has released it)
+func acquireKV(p string) error {
+	kvStores.Lock()
+	defer kvStores.Unlock()
+	s, err := kvStoreFor(p)
+	if err != nil {
+		return err
+	}
+	s.refs++
+	return nil
+}
+
+// releaseKV closes the database for the given path if it's open and not used by another app
+func releaseKV(p string) error {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	kvStores.Lock()
+	defer kvStores.Unlock()
+	s, ok := kvStores.stores[abs]
+	if !ok {
+		return nil
+	}
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+	delete(kvStores.stores, abs)
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Values are prefixed with the expiration time (as Unix nanoseconds, 0 if the key never expires)
+func encodeKVValue(expiresAt int64, js []byte) []byte {
+	buf := make([]byte, 8+len(js))
+	binary.BigEndian.PutUint64(buf, uint64(expiresAt))
+	copy(buf[8:], js)
+	return buf
+}
+
+// decodeKVValue returns the JSON value, or nil if the value has expired
+func decodeKVValue(v []byte, now int64) []byte {
+	if len(v) < 8 {
+		return nil
+	}
+	if expiresAt := int64(binary.BigEndian.Uint64(v)); expiresAt != 0 && expiresAt <= now {
+		return nil
+	}
+	return v[8:]
+}
+
+// kv is the state of the `kv` module for a Lua state
+type kv struct {
+	path string
+	db   *bolt.DB
+	tx   *bolt.Tx // Current transaction (set within `kv.update`/`kv.view`)
+}
+
+// kvBucket is the `bucket` user-defined type
+type kvBucket struct {
+	name []byte
+}
+
+// database returns the database, opening it on first use
+func (s *kv) database(L *lua.LState) *bolt.DB {
+	if s.db == nil {
+		db, err := openKV(s.path)
+		if err != nil {
+			L.RaiseError("failed to open the kv store: %v", err)
+		}
+		s.db = db
+	}
+	return s.db
+}
+
+// run executes the function within the current transaction, or in a new one
+func (s *kv) run(L *lua.LState, writable bool, fn func(tx *bolt.Tx) error) {
+	var err error
+	switch {
+	case s.tx != nil:
+		if writable && !s.tx.Writable() {
+			L.RaiseError("cannot write in a read-only transaction")
+		}
+		err = fn(s.tx)
+	case writable:
+		err = s.database(L).Update(fn)
+	default:
+		err = s.database(L).View(fn)
+	}
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+}
+
+// transaction executes the Lua callback in a transaction, which is rolled back if the callback raises an error
+func (s *kv) transaction(L *lua.LState, writable bool) int {
+	fn := L.CheckFunction(1)
+	if s.tx != nil {
+		L.RaiseError("nested transactions are not supported")
+	}
+	top := L.GetTop()
+	txFn := func(tx *bolt.Tx) error {
+		s.tx = tx
+		defer func() {
+			s.tx = nil
+		}()
+		L.Push(fn)
+		return L.PCall(0, lua.MultRet, nil)
+	}
+	var err error
+	if writable {
+		err = s.database(L).Update(txFn)
+	} else {
+		err = s.database(L).View(txFn)
+	}
+	if err != nil {
+		L.SetTop(top)
+		if lerr, ok := err.(*lua.ApiError); ok {
+			L.Error(lerr.Object, 0)
+		}
+		L.RaiseError("%v", err)
+	}
+	return L.GetTop() - top
+}
+
+func checkKVBucket(L *lua.LState) *kvBucket {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value.(*kvBucket); ok {
+		return v
+	}
+	L.ArgError(1, "bucket expected")
+	return nil
+}
+
+func setupKV(p string) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		s := &kv{path: p}
+
+		mt := L.NewTypeMetatable("kv_bucket")
+		L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"get": func(L *lua.LState) int {
+				b := checkKVBucket(L)
+				key := L.CheckString(2)
+				var js []byte
+				s.run(L, false, func(tx *bolt.Tx) error {
+					if bucket := tx.Bucket(b.name); bucket != nil {
+						if v := decodeKVValue(bucket.Get([]byte(key)), time.Now().UnixNano()); v != nil {
+							js = append([]byte{}, v...)
+						}
+					}
+					return nil
+				})
+				if js == nil {
+					L.Push(lua.LNil)
+					return 1
+				}
+				L.Push(luautil.FromJSON(L, js))
+				return 1
+			},
+			"put": func(L *lua.LState) int {
+				b := checkKVBucket(L)
+				key := L.CheckString(2)
+				js := luautil.ToJSON(L, L.CheckAny(3))
+				var expiresAt int64
+				if ttl := L.OptNumber(4, 0); ttl > 0 {
+					expiresAt = time.Now().Add(time.Duration(float64(ttl) * float64(time.Second))).UnixNano()
+				}
+				s.run(L, true, func(tx *bolt.Tx) error {
+					bucket, err := tx.CreateBucketIfNotExists(b.name)
+					if err != nil {
+						return err
+					}
+					return bucket.Put([]byte(key), encodeKVValue(expiresAt, js))
+				})
+				return 0
+			},
+			"delete": func(L *lua.LState) int {
+				b := checkKVBucket(L)
+				key := L.CheckString(2)
+				s.run(L, true, func(tx *bolt.Tx) error {
+					if bucket := tx.Bucket(b.name); bucket != nil {
+						return bucket.Delete([]byte(key))
+					}
+					return nil
+				})
+				return 0
+			},
+			// Returns an iterator over the (non-expired) keys starting with the prefix, in order
+			"iter": func(L *lua.LState) int {
+				b := checkKVBucket(L)
+				prefix := []byte(L.OptString(2, ""))
+				var keys, values [][]byte
+				s.run(L, false, func(tx *bolt.Tx) error {
+					bucket := tx.Bucket(b.name)
+					if bucket == nil {
+						return nil
+					}
+					now := time.Now().UnixNano()
+					c := bucket.Cursor()
+					for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+						if js := decodeKVValue(v, now); js != nil {
+							keys = append(keys, append([]byte{}, k...))
+							values = append(values, append([]byte{}, js...))
+						}
+					}
+					return nil
+				})
+				var i int
+				L.Push(L.NewFunction(func(L *lua.LState) int {
+					if i >= len(keys) {
+						return 0
+					}
+					L.Push(lua.LString(keys[i]))
+					L.Push(luautil.FromJSON(L, values[i]))
+					i++
+					return 2
+				}))
+				return 1
+			},
+			// Removes the expired keys (they're never returned, but still use space)
+			"purge": func(L *lua.LState) int {
+				b := checkKVBucket(L)
+				var count int
+				s.run(L, true, func(tx *bolt.Tx) error {
+					bucket := tx.Bucket(b.name)
+					if bucket == nil {
+						return nil
+					}
+					now := time.Now().UnixNano()
+					var expired [][]byte
+					c := bucket.Cursor()
+					for k, v := c.First(); k != nil; k, v = c.Next() {
+						if decodeKVValue(v, now) == nil {
+							expired = append(expired, append([]byte{}, k...))
+						}
+					}
+					for _, k := range expired {
+						if err := bucket.Delete(k); err != nil {
+							return err
+						}
+					}
+					count = len(expired)
+					return nil
+				})
+				L.Push(lua.LNumber(count))
+				return 1
+			},
+		}))
+
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"bucket": func(L *lua.LState) int {
+				ud := L.NewUserData()
+				ud.Value = &kvBucket{name: []byte(L.CheckString(1))}
+				L.SetMetatable(ud, L.GetTypeMetatable("kv_bucket"))
+				L.Push(ud)
+				return 1
+			},
+			"delete_bucket": func(L *lua.LState) int {
+				name := []byte(L.CheckString(1))
+				s.run(L, true, func(tx *bolt.Tx) error {
+					if tx.Bucket(name) == nil {
+						return nil
+					}
+					return tx.DeleteBucket(name)
+				})
+				return 0
+			},
+			"update": func(L *lua.LState) int {
+				return s.transaction(L, true)
+			},
+			"view": func(L *lua.LState) int {
+				return s.transaction(L, false)
+			},
+		})
+		L.Push(mod)
+		return 1
+	}
+}
@@ -0,0 +1,202 @@
+package gluapp
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gluapp_kv")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &Config{KVPath: filepath.Join(dir, "kv.db")}
+	defer releaseKV(conf.KVPath)
+
+	testData := []struct {
+		code        string
+		expectedErr string
+	}{
+		{`
+local kv = require('kv')
+local users = kv.bucket('users')
+assert(users:get('thomas') == nil)
+users:put('thomas', {name = 'Thomas', admin = true})
+local user = users:get('thomas')
+assert(user.name == 'Thomas' and user.admin == true)
+users:put('user:1', 1)
+users:put('user:2', 2)
+users:put('user:3', 3, 0.001)
+os.execute('sleep 0.01')
+users:put('zzz', 'z')
+local keys = {}
+for k, v in users:iter('user:') do table.insert(keys, k .. '=' .. v) end
+assert(table.concat(keys, ',') == 'user:1=1,user:2=2', table.concat(keys, ','))
+users:delete('user:1')
+assert(users:get('user:1') == nil)
+`, ""},
+		// The values are persisted across Lua states
+		{`assert(require('kv').bucket('users'):get('thomas').name == 'Thomas')`, ""},
+		// Transactions are rolled back on error
+		{`
+local kv = require('kv')
+local ok, err = pcall(kv.update, function()
+  kv.bucket('users'):put('thomas', 'overwritten')
+  error('oops')
+end)
+assert(not ok and string.find(tostring(err), 'oops'))
+assert(kv.bucket('users'):get('thomas').name == 'Thomas')
+local ret = kv.update(function()
+  local counters = kv.bucket('counters')
+  counters:put('hits', (counters:get('hits') or 0) + 1)
+  return counters:get('hits')
+end)
+assert(ret == 1)
+`, ""},
+		{`require('kv').view(function() require('kv').bucket('users'):put('a', 1) end)`, "read-only transaction"},
+		{`
+local kv = require('kv')
+local users = kv.bucket('users')
+users:put('tmp', 1, 0.001)
+os.execute('sleep 0.01')
+assert(users:purge() >= 1)
+kv.delete_bucket('users')
+assert(users:get('thomas') == nil)
+`, ""},
+	}
+
+	for _, tdata := range testData {
+		L := lua.NewState()
+		if err := NewStdlib(conf).With("kv").Install(L); err != nil {
+			panic(err)
+		}
+		err := L.DoString(tdata.code)
+		L.Close()
+		switch {
+		case tdata.expectedErr == "" && err != nil:
+			t.Errorf("unexpected error for %q: %v", tdata.code, err)
+		case tdata.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tdata.expectedErr)):
+			t.Errorf("bad error for %q, got %v, expected %q", tdata.code, err, tdata.expectedErr)
+		}
+	}
+
+	// Concurrent requests
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			L := lua.NewState()
+			defer L.Close()
+			if err := NewStdlib(conf).With("kv").Install(L); err != nil {
+				panic(err)
+			}
+			if err := L.DoString(`
+local kv = require('kv')
+kv.update(function()
+  local counters = kv.bucket('counters')
+  counters:put('hits', (counters:get('hits') or 0) + 1)
+end)
+`); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(conf).With("kv").Install(L); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(`hits = require('kv').bucket('counters'):get('hits')`); err != nil {
+		panic(err)
+	}
+	if hits := L.GetGlobal("hits"); hits != lua.LNumber(11) {
+		t.Errorf("bad hits count, got %v, expected 11", hits)
+	}
+}
+
+func TestKVSharedByApps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gluapp_kv")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	kvPath := filepath.Join(dir, "kv.db")
+
+	var apps []*App
+	for i := 0; i < 2; i++ {
+		app, err := NewApp(&Config{Path: "tests_data/app/", KVPath: kvPath})
+		if err != nil {
+			panic(err)
+		}
+		apps = append(apps, app)
+	}
+	db, err := openKV(kvPath)
+	if err != nil {
+		panic(err)
+	}
+
+	// The database stays open until the last app is closed
+	if err := apps[0].Close(); err != nil {
+		panic(err)
+	}
+	if err := db.View(func(*bolt.Tx) error { return nil }); err != nil {
+		t.Errorf("the database should still be open: %v", err)
+	}
+	if err := apps[1].Close(); err != nil {
+		panic(err)
+	}
+	if err := db.View(func(*bolt.Tx) error { return nil }); err != bolt.ErrDatabaseNotOpen {
+		t.Errorf("the database should be closed, got %v", err)
+	}
+}
+
+func TestKVReleasedByGlue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gluapp_kv")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &Config{KVPath: filepath.Join(dir, "kv.db")}
+	code := `require('kv').bucket('b'):put('k', 1)`
+
+	// The database lock must be released for another process to open it
+	checkReleased := func(name string) {
+		db, err := bolt.Open(conf.KVPath, 0600, &bolt.Options{Timeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Errorf("%s: the database should be closed: %v", name, err)
+			return
+		}
+		db.Close()
+	}
+
+	if err := Exec(conf, code, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+		panic(err)
+	}
+	checkReleased("Exec")
+
+	L := lua.NewState()
+	if err := SetupGlue(L, conf, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(code); err != nil {
+		panic(err)
+	}
+	L.Close()
+	if err := ReleaseGlue(conf); err != nil {
+		panic(err)
+	}
+	checkReleased("SetupGlue")
+}
@@ -0,0 +1,200 @@
+package gluapp
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"a4.io/blobstash/pkg/apps/luautil"
+	"github.com/yuin/gopher-lua"
+)
+
+// The `log` module (also available as the `log` global) sends structured log entries to `Config.Logger`:
+//
+//	local log = require('log')
+//	log.info('saved', {id = 1})
+//	log.error('failed to save', {id = 1, err = err})
+//	log('legacy %s', 'format') -- `string.format` style, sent to `Config.LogHook` if set
+//
+// The entries include the request ID (from the `X-Request-Id` header, or generated) and the Lua source location.
+
+// LogLevel represents the severity of a log entry.
+type LogLevel int
+
+// Log levels
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level (as used in Lua).
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevels[l]
+}
+
+// LogEntry represents a log entry emitted from Lua.
+type LogEntry struct {
+	Time      time.Time
+	Level     LogLevel
+	Message   string
+	Fields    map[string]interface{}
+	RequestID string // Empty outside of a request
+	Source    string // Lua source location (`file.lua:12`)
+}
+
+// Logger receives the log entries emitted from Lua.
+type Logger interface {
+	Log(entry *LogEntry) error
+}
+
+// JSONLogger is a Logger writing entries as JSON lines.
+type JSONLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel LogLevel
+}
+
+// NewJSONLogger returns a logger writing the entries with at least the given level to w.
+func NewJSONLogger(w io.Writer, minLevel LogLevel) *JSONLogger {
+	return &JSONLogger{w: w, minLevel: minLevel}
+}
+
+// Log implements the Logger interface.
+func (l *JSONLogger) Log(entry *LogEntry) error {
+	if entry.Level < l.minLevel {
+		return nil
+	}
+	line := map[string]interface{}{
+		"time":  entry.Time.Format(time.RFC3339Nano),
+		"level": entry.Level.String(),
+		"msg":   entry.Message,
+	}
+	if len(entry.Fields) > 0 {
+		line["fields"] = entry.Fields
+	}
+	if entry.RequestID != "" {
+		line["request_id"] = entry.RequestID
+	}
+	if entry.Source != "" {
+		line["source"] = entry.Source
+	}
+	js, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(js, '\n'))
+	return err
+}
+
+// Used if `Config.Logger` is not set
+var defaultLogger = NewJSONLogger(os.Stdout, LevelDebug)
+
+// requestID returns the ID of the request, from the `X-Request-Id` header, or a generated one
+func requestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+// legacyLog implements the `log(format, ...)` call, formatted with `string.format`
+func legacyLog(L *lua.LState, conf *Config, logger Logger, reqID string) int {
+	var args []lua.LValue
+	for i := 1; i <= L.GetTop(); i++ {
+		item := L.Get(i)
+		// We don't want table to be displayed as "table: 0xc420272240"
+		if t, ok := item.(*lua.LTable); ok {
+			item = lua.LString(luautil.ToJSON(L, t))
+		}
+		args = append(args, item)
+	}
+
+	// Call `string.format`
+	if err := L.CallByParam(lua.P{
+		Fn:      lua.LValue(L.GetField(L.GetGlobal("string"), "format").(*lua.LFunction)),
+		NRet:    1,
+		Protect: true,
+	}, args...); err != nil {
+		panic(err)
+	}
+
+	// Get the result
+	logLine := string(L.Get(-1).(lua.LString))
+	L.Pop(1)
+
+	// Execute the hook
+	if conf.LogHook != nil {
+		if err := conf.LogHook(logLine); err != nil {
+			panic(err)
+		}
+		return 0
+	}
+	if err := logger.Log(&LogEntry{
+		Time:      time.Now(),
+		Level:     LevelInfo,
+		Message:   logLine,
+		RequestID: reqID,
+		Source:    strings.TrimSuffix(L.Where(1), ":"),
+	}); err != nil {
+		L.RaiseError("failed to log: %v", err)
+	}
+	return 0
+}
+
+// setupLog returns the `log` module, the request ID is attached to the entries
+func setupLog(L *lua.LState, conf *Config, reqID string) *lua.LTable {
+	logger := conf.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+	mod := L.NewTable()
+	for level, name := range logLevels {
+		level := LogLevel(level)
+		L.SetField(mod, name, L.NewFunction(func(L *lua.LState) int {
+			entry := &LogEntry{
+				Time:      time.Now(),
+				Level:     level,
+				Message:   L.CheckString(1),
+				RequestID: reqID,
+				Source:    strings.TrimSuffix(L.Where(1), ":"),
+			}
+			if fields := L.OptTable(2, nil); fields != nil {
+				entry.Fields = luautil.TableToMap(L, fields)
+			}
+			if err := logger.Log(entry); err != nil {
+				L.RaiseError("failed to log: %v", err)
+			}
+			return 0
+		}))
+	}
+	// Keep `log(format, ...)` working
+	mt := L.NewTable()
+	L.SetField(mt, "__call", L.NewFunction(func(L *lua.LState) int {
+		L.Remove(1) // The module
+		return legacyLog(L, conf, logger, reqID)
+	}))
+	L.SetMetatable(mod, mt)
+	return mod
+}
@@ -0,0 +1,106 @@
+package gluapp
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type testLogger struct {
+	entries []*LogEntry
+}
+
+func (l *testLogger) Log(entry *LogEntry) error {
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func TestLog(t *testing.T) {
+	logger := &testLogger{}
+	var hookLines []string
+	conf := &Config{Logger: logger}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "req-1")
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(conf).With("log").Request(httptest.NewRecorder(), r).Install(L); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(`local log = require('log')
+log.debug('starting')
+log.info('saved', {id = 1, tags = {'a'}})
+log.error('failed')
+log('legacy %d', 2)
+`); err != nil {
+		panic(err)
+	}
+
+	expected := []struct {
+		level   LogLevel
+		message string
+		source  string
+	}{
+		{LevelDebug, "starting", "<string>:2"},
+		{LevelInfo, "saved", "<string>:3"},
+		{LevelError, "failed", "<string>:4"},
+		{LevelInfo, "legacy 2", "<string>:5"},
+	}
+	if len(logger.entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(logger.entries))
+	}
+	for i, e := range expected {
+		entry := logger.entries[i]
+		if entry.Level != e.level || entry.Message != e.message || entry.Source != e.source || entry.RequestID != "req-1" {
+			t.Errorf("bad entry %d, got %+v", i, entry)
+		}
+		if entry.Time.IsZero() {
+			t.Errorf("missing time for entry %d", i)
+		}
+	}
+	if fields := logger.entries[1].Fields; fields["id"] != float64(1) {
+		t.Errorf("bad fields, got %+v", fields)
+	}
+
+	// The legacy calls are sent to the hook if set
+	conf.LogHook = func(logLine string) error {
+		hookLines = append(hookLines, logLine)
+		return nil
+	}
+	L2 := lua.NewState()
+	defer L2.Close()
+	if err := NewStdlib(conf).With("log").Install(L2); err != nil {
+		panic(err)
+	}
+	if err := L2.DoString(`log('hello %s', 'world'); log.warn('still structured')`); err != nil {
+		panic(err)
+	}
+	if len(hookLines) != 1 || hookLines[0] != "hello world" {
+		t.Errorf("bad hook lines, got %q", hookLines)
+	}
+	if last := logger.entries[len(logger.entries)-1]; last.Level != LevelWarn || last.RequestID != "" {
+		t.Errorf("bad entry outside of a request, got %+v", last)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, LevelInfo)
+	now := time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC)
+	for _, entry := range []*LogEntry{
+		{Time: now, Level: LevelDebug, Message: "skipped"},
+		{Time: now, Level: LevelWarn, Message: "saved", Fields: map[string]interface{}{"id": 1}, RequestID: "req-1",
+			Source: "app.lua:3"},
+	} {
+		if err := logger.Log(entry); err != nil {
+			panic(err)
+		}
+	}
+	expected := `{"fields":{"id":1},"level":"warn","msg":"saved","request_id":"req-1","source":"app.lua:3","time":"2020-03-14T15:09:26Z"}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("bad output, got %q", buf.String())
+	}
+}
@@ -0,0 +1,236 @@
+package gluapp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default histogram buckets (in seconds), same as the Prometheus client
+var defaultMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the metrics of the requests served by `App.ServeHTTP` and of the `http` module calls, it's
+// enabled with `Config.Metrics`:
+//
+//	metrics := gluapp.NewMetrics()
+//	app, err := gluapp.NewApp(&gluapp.Config{Path: "app", Metrics: metrics})
+//	http.Handle("/metrics", metrics)
+//
+// The metrics are exposed in the Prometheus text format, the requests are labelled by method, matched route pattern
+// (empty for the public files and the unmatched paths) and status.
+type Metrics struct {
+	mu            sync.Mutex
+	buckets       []float64
+	requests      map[string]*requestMetrics // Keyed by the formatted labels
+	httpCalls     map[string]uint64
+	httpDurations map[string]*histogram
+}
+
+type requestMetrics struct {
+	errors   uint64
+	duration *histogram // Total time (the count is the number of requests)
+	lua      *histogram // Time spent executing Lua
+}
+
+type histogram struct {
+	counts []uint64 // Non-cumulative, by bucket
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if i := sort.SearchFloat64s(buckets, v); i < len(buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// NewMetrics returns an empty metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:       defaultMetricsBuckets,
+		requests:      map[string]*requestMetrics{},
+		httpCalls:     map[string]uint64{},
+		httpDurations: map[string]*histogram{},
+	}
+}
+
+// metricLabels formats the label pairs (name, value, name, value...)
+func metricLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i < len(pairs); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		labels = append(labels, pairs[i]+`="`+v+`"`)
+	}
+	return strings.Join(labels, ",")
+}
+
+// metricMethod returns the method label, the unknown methods are grouped as "OTHER" (to bound the number of series)
+func metricMethod(method string) string {
+	for _, m := range methods {
+		if method == m {
+			return method
+		}
+	}
+	return "OTHER"
+}
+
+// observeRequest records a request, `luaDuration` is 0 if no Lua code was executed
+func (m *Metrics) observeRequest(method, route string, status int, failed bool, duration, luaDuration time.Duration) {
+	method = metricMethod(method)
+	key := metricLabels("method", method, "route", route, "status", strconv.Itoa(status))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rm, ok := m.requests[key]
+	if !ok {
+		rm = &requestMetrics{duration: newHistogram(m.buckets), lua: newHistogram(m.buckets)}
+		m.requests[key] = rm
+	}
+	if failed || status >= 500 {
+		rm.errors++
+	}
+	rm.duration.observe(m.buckets, duration.Seconds())
+	if luaDuration > 0 {
+		rm.lua.observe(m.buckets, luaDuration.Seconds())
+	}
+}
+
+// observeHTTPCall records an outgoing request, `status` is "error" if the request failed
+func (m *Metrics) observeHTTPCall(method, host, status string, duration time.Duration) {
+	method = metricMethod(method)
+	key := metricLabels("method", method, "host", host)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.httpCalls[metricLabels("method", method, "host", host, "status", status)]++
+	h, ok := m.httpDurations[key]
+	if !ok {
+		h = newHistogram(m.buckets)
+		m.httpDurations[key] = h
+	}
+	h.observe(m.buckets, duration.Seconds())
+}
+
+// metricsTransport records the calls made with the `http` module
+type metricsTransport struct {
+	metrics   *Metrics
+	transport http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(r)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	t.metrics.observeHTTPCall(r.Method, r.URL.Host, status, time.Since(start))
+	return resp, err
+}
+
+// instrumentClient returns a copy of the client recording its calls
+func (m *Metrics) instrumentClient(client *http.Client) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &metricsTransport{metrics: m, transport: transport}
+	return &c
+}
+
+// sortedKeys returns the keys of the map sorted
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*requestMetrics:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeHistogram(w io.Writer, name, labels string, buckets []float64, h *histogram) {
+	var cumulative uint64
+	for i, b := range buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(b, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	return m.format().WriteTo(w)
+}
+
+func (m *Metrics) format() *bytes.Buffer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf := &bytes.Buffer{}
+
+	requests := sortedKeys(m.requests)
+	writeMetricHeader(buf, "gluapp_requests_total", "counter", "Number of requests served.")
+	for _, k := range requests {
+		fmt.Fprintf(buf, "gluapp_requests_total{%s} %d\n", k, m.requests[k].duration.count)
+	}
+	writeMetricHeader(buf, "gluapp_request_errors_total", "counter",
+		"Number of requests that failed (Lua errors and 5xx statuses).")
+	for _, k := range requests {
+		fmt.Fprintf(buf, "gluapp_request_errors_total{%s} %d\n", k, m.requests[k].errors)
+	}
+	writeMetricHeader(buf, "gluapp_request_duration_seconds", "histogram", "Total time spent serving the requests.")
+	for _, k := range requests {
+		writeHistogram(buf, "gluapp_request_duration_seconds", k, m.buckets, m.requests[k].duration)
+	}
+	writeMetricHeader(buf, "gluapp_lua_duration_seconds", "histogram", "Time spent executing Lua code for the requests.")
+	for _, k := range requests {
+		if lua := m.requests[k].lua; lua.count > 0 {
+			writeHistogram(buf, "gluapp_lua_duration_seconds", k, m.buckets, lua)
+		}
+	}
+
+	writeMetricHeader(buf, "gluapp_http_client_requests_total", "counter", "Number of requests made with the http module.")
+	for _, k := range sortedKeys(m.httpCalls) {
+		fmt.Fprintf(buf, "gluapp_http_client_requests_total{%s} %d\n", k, m.httpCalls[k])
+	}
+	writeMetricHeader(buf, "gluapp_http_client_request_duration_seconds", "histogram",
+		"Time spent waiting for the responses of the requests made with the http module.")
+	for _, k := range sortedKeys(m.httpDurations) {
+		writeHistogram(buf, "gluapp_http_client_request_duration_seconds", k, m.buckets, m.httpDurations[k])
+	}
+
+	return buf
+}
+
+// ServeHTTP implements the `http.Handler` interface.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
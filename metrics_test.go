@@ -0,0 +1,93 @@
+package gluapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	app, err := NewApp(&Config{Path: "tests_data/app/", Metrics: metrics})
+	if err != nil {
+		panic(err)
+	}
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/bar"},
+		{"GET", "/bar"},
+		{"POST", "/posts/1"},
+		{"POST", "/posts/2"},
+		{"GET", "/js/app.js"},
+		{"GET", "/nope"},
+	} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	// The `http` module calls
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(&Config{Metrics: metrics}).With("http").Install(L); err != nil {
+		panic(err)
+	}
+	L.SetGlobal("server_url", lua.LString(server.URL))
+	if err := L.DoString(`require('http').new():get(server_url)`); err != nil {
+		panic(err)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("bad content type, got %q", ct)
+	}
+	out := w.Body.String()
+	for _, expected := range []string{
+		"# TYPE gluapp_requests_total counter\n",
+		`gluapp_requests_total{method="GET",route="/bar",status="200"} 2` + "\n",
+		`gluapp_requests_total{method="POST",route="/posts/:id",status="200"} 2` + "\n",
+		`gluapp_requests_total{method="GET",route="",status="200"} 1` + "\n",
+		`gluapp_requests_total{method="GET",route="",status="404"} 1` + "\n",
+		`gluapp_request_errors_total{method="GET",route="/bar",status="200"} 0` + "\n",
+		"# TYPE gluapp_request_duration_seconds histogram\n",
+		`gluapp_request_duration_seconds_bucket{method="GET",route="/bar",status="200",le="+Inf"} 2` + "\n",
+		`gluapp_request_duration_seconds_count{method="POST",route="/posts/:id",status="200"} 2` + "\n",
+		`gluapp_lua_duration_seconds_count{method="GET",route="/bar",status="200"} 2` + "\n",
+		`gluapp_http_client_requests_total{method="GET",host="` + host + `",status="418"} 1` + "\n",
+		`gluapp_http_client_request_duration_seconds_count{method="GET",host="` + host + `"} 1` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("missing %q in:\n%s", expected, out)
+		}
+	}
+	// No Lua code is executed for the public files
+	if strings.Contains(out, `gluapp_lua_duration_seconds_count{method="GET",route="",status="200"}`) {
+		t.Errorf("unexpected Lua duration for a public file")
+	}
+}
+
+func TestMetricsErrors(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeRequest("GET", "/", 500, false, 0, 0)
+	metrics.observeRequest("GET", "/", 500, true, 0, 0)
+	metrics.observeRequest("GET", `/a"b`, 200, false, 0, 0)
+	metrics.observeRequest("PROPFIND", "/", 404, false, 0, 0)
+	metrics.observeRequest("get", "/", 404, false, 0, 0)
+	out := metrics.format().String()
+	for _, expected := range []string{
+		`gluapp_request_errors_total{method="GET",route="/",status="500"} 2` + "\n",
+		`gluapp_request_errors_total{method="GET",route="/a\"b",status="200"} 0` + "\n",
+		`gluapp_request_duration_seconds_bucket{method="GET",route="/",status="500",le="0.005"} 2` + "\n",
+		`gluapp_requests_total{method="OTHER",route="/",status="404"} 2` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("missing %q in:\n%s", expected, out)
+		}
+	}
+}
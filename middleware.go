@@ -0,0 +1,47 @@
+package gluapp
+
+import (
+	"net/http"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Handler executes the Lua app for the request, the Lua state is already set up (all the modules are preloaded and
+// the `app` global is set) and the output of the script is collected in `resp`.
+type Handler func(r *http.Request, L *lua.LState, resp *Response) error
+
+// Middleware wraps the execution of the Lua app, it can act before and after the script execution (by calling
+// `next`), or skip it entirely (by not calling `next`), e.g.:
+//
+//	app.Use(func(next gluapp.Handler) gluapp.Handler {
+//		return func(r *http.Request, L *lua.LState, resp *gluapp.Response) error {
+//			if r.Header.Get("Authorization") == "" {
+//				resp.StatusCode = http.StatusUnauthorized
+//				resp.Body = []byte(http.StatusText(http.StatusUnauthorized))
+//				return nil
+//			}
+//			if err := next(r, L, resp); err != nil {
+//				return err
+//			}
+//			resp.Header.Set("X-Powered-By", "gluapp")
+//			return nil
+//		}
+//	})
+//
+// Once `next` returns, `resp.Body` contains the output of the script. Requests not handled by Lua (public/ files,
+// static mounts...) don't go through the middlewares.
+type Middleware func(next Handler) Handler
+
+// Use appends middlewares to the chain (the first one is the outermost), it must be called before the app starts
+// serving requests.
+func (a *App) Use(mws ...Middleware) {
+	a.middlewares = append(a.middlewares, mws...)
+}
+
+// chain wraps the handler with the middlewares
+func (a *App) chain(h Handler) Handler {
+	for i := len(a.middlewares) - 1; i >= 0; i-- {
+		h = a.middlewares[i](h)
+	}
+	return h
+}
@@ -0,0 +1,117 @@
+package gluapp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestMiddlewares(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/app/"})
+	if err != nil {
+		panic(err)
+	}
+
+	var calls []string
+	app.Use(
+		// Require a token
+		func(next Handler) Handler {
+			return func(r *http.Request, L *lua.LState, resp *Response) error {
+				calls = append(calls, "auth")
+				if r.Header.Get("X-Token") != "ok" {
+					resp.StatusCode = http.StatusUnauthorized
+					resp.Body = []byte(http.StatusText(http.StatusUnauthorized))
+					return nil
+				}
+				return next(r, L, resp)
+			}
+		},
+		// Rewrite the response
+		func(next Handler) Handler {
+			return func(r *http.Request, L *lua.LState, resp *Response) error {
+				calls = append(calls, "rewrite")
+				if L.GetGlobal("app") == lua.LNil {
+					t.Errorf("the Lua state is not set up")
+				}
+				if err := next(r, L, resp); err != nil {
+					return err
+				}
+				resp.Header.Set("X-Body-Size", strconv.Itoa(len(resp.Body)))
+				resp.Body = append(resp.Body, '!')
+				return nil
+			}
+		},
+	)
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	testData := []struct {
+		token                      string
+		path                       string
+		expectedCalls              int
+		expectedResponseBody       string
+		expectedResponseStatusCode int
+		expectedHeader             string
+	}{
+		{"", "/bar", 1, "Unauthorized", 401, ""},
+		{"ok", "/bar", 2, "bar!", 200, "3"},
+		// Files from public/ are not handled by Lua
+		{"", "/lol.html", 0, "lol\n", 200, ""},
+	}
+
+	for _, tdata := range testData {
+		calls = nil
+		req, err := http.NewRequest("GET", server.URL+tdata.path, nil)
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("X-Token", tdata.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if resp.StatusCode != tdata.expectedResponseStatusCode {
+			t.Errorf("bad status code for %s, got %d, expected %d", tdata.path, resp.StatusCode, tdata.expectedResponseStatusCode)
+		}
+		if string(body) != tdata.expectedResponseBody {
+			t.Errorf("bad body for %s, got %q, expected %q", tdata.path, body, tdata.expectedResponseBody)
+		}
+		if h := resp.Header.Get("X-Body-Size"); h != tdata.expectedHeader {
+			t.Errorf("bad header for %s, got %q, expected %q", tdata.path, h, tdata.expectedHeader)
+		}
+		if len(calls) != tdata.expectedCalls {
+			t.Errorf("bad middleware calls for %s, got %v", tdata.path, calls)
+		}
+	}
+}
+
+func TestMiddlewareWriteAfterNext(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/app/"})
+	if err != nil {
+		panic(err)
+	}
+	app.Use(func(next Handler) Handler {
+		return func(r *http.Request, L *lua.LState, resp *Response) error {
+			if err := next(r, L, resp); err != nil {
+				return err
+			}
+			return L.DoString(`app.response:write(' after')`)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/bar", nil))
+	if body := w.Body.String(); body != "bar after" {
+		t.Errorf("bad body, got %q, expected %q", body, "bar after")
+	}
+}
@@ -0,0 +1,398 @@
+package gluapp
+
+import (
+	"strings"
+)
+
+// The minifiers below are conservative: they remove comments and collapse whitespace, but never rewrite the code.
+//
+// A JavaScript `/` following a `)` can be a division or start a regexp literal (`if (ok) /re/.test(s)`), telling them
+// apart would need a parser, so the rest of the line is kept as is.
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// copyString copies a quoted string (starting at `i`, handling escapes) to the output and returns the index just
+// after it
+func copyString(out *strings.Builder, s string, i int) int {
+	quote := s[i]
+	out.WriteByte(quote)
+	i++
+	for i < len(s) {
+		c := s[i]
+		out.WriteByte(c)
+		i++
+		if c == '\\' && i < len(s) {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+		if c == quote {
+			break
+		}
+	}
+	return i
+}
+
+// minifyCSS removes the comments (except the ones starting with `/*!`), collapses whitespace and removes the
+// unnecessary spaces/semicolons.
+func minifyCSS(s string) string {
+	var out []byte
+	// Whitespace is not needed next to these characters (`:` is not part of it since `a :hover` != `a:hover`)
+	noSpace := "{};,>"
+	var pendingSpace bool
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				end = len(s)
+			} else {
+				end = i + 2 + end + 2
+			}
+			if i+2 < len(s) && s[i+2] == '!' {
+				out = append(out, s[i:end]...)
+			}
+			i = end
+			continue
+		case isSpace(c):
+			pendingSpace = true
+			i++
+			continue
+		}
+
+		if pendingSpace {
+			if len(out) > 0 && strings.IndexByte(noSpace, out[len(out)-1]) == -1 && strings.IndexByte(noSpace, c) == -1 {
+				out = append(out, ' ')
+			}
+			pendingSpace = false
+		}
+
+		switch c {
+		case '"', '\'':
+			var str strings.Builder
+			i = copyString(&str, s, i)
+			out = append(out, str.String()...)
+			continue
+		case '}':
+			// Remove the last semicolon of a block
+			if len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+		}
+		out = append(out, c)
+		i++
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// regexpCanFollow returns true if a `/` after the given code starts a regexp literal (instead of a division)
+func regexpCanFollow(code string) bool {
+	code = strings.TrimRight(code, " \t\n\r")
+	if code == "" {
+		return true
+	}
+	if strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(code[len(code)-1])) {
+		return true
+	}
+	for _, kw := range []string{"return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else"} {
+		if strings.HasSuffix(code, kw) {
+			prefix := code[:len(code)-len(kw)]
+			if prefix == "" || !isIdentChar(prefix[len(prefix)-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// slashIsAmbiguous returns true if a `/` after the given code can be either a division or a regexp literal
+func slashIsAmbiguous(code string) bool {
+	code = strings.TrimRight(code, " \t\n\r")
+	return code != "" && code[len(code)-1] == ')'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// minifyJS removes the comments (except the ones starting with `/*!`), collapses whitespace and removes empty lines,
+// newlines are kept to not break the automatic semicolon insertion.
+func minifyJS(s string) string {
+	var out strings.Builder
+	var pendingSpace, pendingNewline bool
+	flush := func() {
+		if out.Len() > 0 {
+			if pendingNewline {
+				out.WriteByte('\n')
+			} else if pendingSpace {
+				out.WriteByte(' ')
+			}
+		}
+		pendingSpace, pendingNewline = false, false
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\n' || c == '\r':
+			pendingNewline = true
+			i++
+		case isSpace(c):
+			pendingSpace = true
+			i++
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			end := strings.IndexByte(s[i:], '\n')
+			if end == -1 {
+				end = len(s)
+			} else {
+				end = i + end
+			}
+			i = end
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				end = len(s)
+			} else {
+				end = i + 2 + end + 2
+			}
+			if i+2 < len(s) && s[i+2] == '!' {
+				flush()
+				out.WriteString(s[i:end])
+			} else if strings.ContainsAny(s[i:end], "\n\r") {
+				pendingNewline = true
+			} else {
+				pendingSpace = true
+			}
+			i = end
+		case c == '/' && slashIsAmbiguous(out.String()):
+			flush()
+			// Keep the rest of the line, and the rest of the input if a multiline comment, template literal or string
+			// may continue on the next line
+			end := strings.IndexAny(s[i:], "\n\r")
+			if end == -1 {
+				end = len(s)
+			} else {
+				end = i + end
+			}
+			if line := s[i:end]; strings.ContainsAny(line, "`") || strings.Contains(line, "/*") || strings.HasSuffix(line, "\\") {
+				end = len(s)
+			}
+			out.WriteString(s[i:end])
+			i = end
+		case c == '/' && regexpCanFollow(out.String()):
+			flush()
+			// Copy the regexp literal (a `/` inside a class does not end it)
+			var inClass bool
+			out.WriteByte(c)
+			i++
+			for i < len(s) && s[i] != '\n' {
+				rc := s[i]
+				out.WriteByte(rc)
+				i++
+				if rc == '\\' && i < len(s) {
+					out.WriteByte(s[i])
+					i++
+					continue
+				}
+				if rc == '[' {
+					inClass = true
+				} else if rc == ']' {
+					inClass = false
+				} else if rc == '/' && !inClass {
+					break
+				}
+			}
+		case c == '"' || c == '\'':
+			flush()
+			i = copyString(&out, s, i)
+		case c == '`':
+			flush()
+			// Copy the template literal, including the `${}` expressions
+			var depth int
+			out.WriteByte(c)
+			i++
+			for i < len(s) {
+				tc := s[i]
+				out.WriteByte(tc)
+				i++
+				if tc == '\\' && i < len(s) {
+					out.WriteByte(s[i])
+					i++
+					continue
+				}
+				if tc == '$' && i < len(s) && s[i] == '{' {
+					depth++
+					out.WriteByte('{')
+					i++
+					continue
+				}
+				if tc == '}' && depth > 0 {
+					depth--
+					continue
+				}
+				if tc == '`' && depth == 0 {
+					break
+				}
+			}
+		default:
+			flush()
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// Elements whose content must be kept as is
+var rawHTMLElements = []string{"pre", "textarea", "script", "style"}
+
+// minifyHTML removes the comments (except the conditional ones) and collapses whitespace, inline `<style>` and
+// `<script>` elements are minified too. It also works for SVG documents.
+func minifyHTML(s string) string {
+	var out strings.Builder
+	var pendingSpace bool
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], "<!--"):
+			end := strings.Index(s[i+4:], "-->")
+			if end == -1 {
+				end = len(s)
+			} else {
+				end = i + 4 + end + 3
+			}
+			if strings.HasPrefix(s[i:], "<!--[if") {
+				out.WriteString(s[i:end])
+			}
+			i = end
+		case isSpace(c):
+			pendingSpace = true
+			i++
+		case c == '<' && i+1 < len(s) && (s[i+1] == '/' || s[i+1] == '!' || s[i+1] == '?' || isIdentChar(s[i+1])):
+			if pendingSpace && out.Len() > 0 {
+				out.WriteByte(' ')
+			}
+			pendingSpace = false
+
+			// Copy the tag, collapsing the whitespace between the attributes
+			start := i
+			var tag strings.Builder
+			tag.WriteByte(c)
+			i++
+			var space bool
+			for i < len(s) && s[i] != '>' {
+				tc := s[i]
+				switch {
+				case tc == '"' || tc == '\'':
+					if space {
+						tag.WriteByte(' ')
+						space = false
+					}
+					end := strings.IndexByte(s[i+1:], tc)
+					if end == -1 {
+						// Unterminated attribute value
+						tag.WriteString(s[i:])
+						i = len(s)
+						continue
+					}
+					tag.WriteString(s[i : i+1+end+1])
+					i += end + 2
+					continue
+				case isSpace(tc):
+					space = true
+				default:
+					if space && tc != '/' {
+						tag.WriteByte(' ')
+					}
+					space = false
+					tag.WriteByte(tc)
+				}
+				i++
+			}
+			if i < len(s) {
+				tag.WriteByte('>')
+				i++
+			}
+			out.WriteString(tag.String())
+
+			// Check if the content of the element must be preserved
+			for _, name := range rawHTMLElements {
+				if !hasPrefixFold(s[start:], "<"+name) || start+1+len(name) >= len(s) {
+					continue
+				}
+				if next := s[start+1+len(name)]; !isSpace(next) && next != '>' && next != '/' {
+					continue
+				}
+				end := indexFold(s[i:], "</"+name)
+				if end == -1 {
+					end = len(s)
+				} else {
+					end = i + end
+				}
+				content := s[i:end]
+				switch name {
+				case "style":
+					content = minifyCSS(content)
+				case "script":
+					if t := strings.ToLower(tag.String()); !strings.Contains(t, "type=") || strings.Contains(t, "javascript") {
+						content = minifyJS(content)
+					}
+				}
+				out.WriteString(content)
+				i = end
+				break
+			}
+		default:
+			if pendingSpace && out.Len() > 0 {
+				out.WriteByte(' ')
+			}
+			pendingSpace = false
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// hasPrefixFold is like `strings.HasPrefix`, but ignores the ASCII case (the prefix must be lowercase), the offsets
+// stay valid for non-ASCII text unlike with `strings.ToLower`
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFold is like `strings.Index`, but ignores the ASCII case (the substring must be lowercase)
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if hasPrefixFold(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// minifyContentType returns the minifier to use for the given content type, if any
+func minifyContentType(contentType string) func(string) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/css"):
+		return minifyCSS
+	case strings.Contains(contentType, "javascript"):
+		return minifyJS
+	case strings.HasPrefix(contentType, "text/html"), strings.HasPrefix(contentType, "image/svg+xml"):
+		return minifyHTML
+	}
+	return nil
+}
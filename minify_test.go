@@ -0,0 +1,31 @@
+package gluapp
+
+import (
+	"testing"
+)
+
+var testMinify = []struct {
+	minify       func(string) string
+	in, expected string
+}{
+	{minifyCSS, "/* comment */\nbody {\n  color: red;\n  margin: 0 auto;\n}\n\na :hover, b > i { content: \"a  b\"; }\n", "body{color: red;margin: 0 auto}a :hover,b>i{content: \"a  b\"}"},
+	{minifyCSS, "/*! license */ @media screen and (max-width: 10px) { a { b: c } }", "/*! license */ @media screen and (max-width: 10px){a{b: c}}"},
+	{minifyJS, "// comment\nvar a = 1;   /* inline */ var b = 'x // y';\n\n\nvar re = /\\/* [/]/g;\nvar c = a / b / 2;\n", "var a = 1; var b = 'x // y';\nvar re = /\\/* [/]/g;\nvar c = a / b / 2;"},
+	{minifyJS, "const t = `a  ${ `b` }  // c`;\nreturn /x/.test(t)", "const t = `a  ${ `b` }  // c`;\nreturn /x/.test(t)"},
+	// A `/` after `)` may start a regexp literal
+	{minifyJS, "if (ok) /https?:\\/\\//.test(url) && go()  // go\n\n  var a = (b) / 2;", "if (ok) /https?:\\/\\//.test(url) && go()  // go\nvar a = (b) / 2;"},
+	{minifyJS, "var a = (b) / 2; /* x\n  y */  var c;", "var a = (b) / 2; /* x\n  y */  var c;"},
+	{minifyHTML, "<!-- comment -->\n<div   class=\"a  b\"  >\n  <p>Hello   <b>World</b></p>\n  <pre>  keep\n  this </pre>\n</div>\n", "<div class=\"a  b\"> <p>Hello <b>World</b></p> <pre>  keep\n  this </pre> </div>"},
+	{minifyHTML, "<style>\n  a { color: red; }\n</style>\n<script>\n  // comment\n  var a = 1 < 2;\n</script>\n<p>1 < 2</p>", "<style>a{color: red}</style> <script>var a = 1 < 2;</script> <p>1 < 2</p>"},
+	// `strings.ToLower` changes the length of some non-ASCII text
+	{minifyHTML, "<p>ẞẞẞẞẞẞẞẞ</p>  <b>x</b><PRE> Ⱥ  ẞ </Pre>", "<p>ẞẞẞẞẞẞẞẞ</p> <b>x</b><PRE> Ⱥ  ẞ </Pre>"},
+	{minifyHTML, "<svg  viewBox=\"0 0 10 10\">\n  <path d=\"M 0 0\" />\n</svg>", "<svg viewBox=\"0 0 10 10\"> <path d=\"M 0 0\"/> </svg>"},
+}
+
+func TestMinify(t *testing.T) {
+	for _, tdata := range testMinify {
+		if out := tdata.minify(tdata.in); out != tdata.expected {
+			t.Errorf("minify error, got %q, expected %q", out, tdata.expected)
+		}
+	}
+}
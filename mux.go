@@ -0,0 +1,146 @@
+package gluapp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// scriptRootKey is the context key holding the path prefix the app is mounted at
+type scriptRootKey struct{}
+
+// scriptRoot returns the path prefix the app is mounted at (without trailing slash), or an empty string
+func scriptRoot(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if root, ok := r.Context().Value(scriptRootKey{}).(string); ok {
+		return root
+	}
+	return ""
+}
+
+// prefixApp represents an app mounted under a path prefix
+type prefixApp struct {
+	prefix string
+	app    *App
+}
+
+// Mux serves multiple apps from a single `http.Handler`, requests are dispatched by host (the `Host` header) first,
+// then by path prefix (the longest prefix wins).
+//
+// When an app is mounted under a prefix, the prefix is stripped from the request path and can be retrieved with
+// `app.request:script_root()` to build URLs.
+type Mux struct {
+	hosts    map[string]*App
+	prefixes []*prefixApp
+}
+
+// NewMux returns an empty `Mux`
+func NewMux() *Mux {
+	return &Mux{
+		hosts: map[string]*App{},
+	}
+}
+
+// Host serves the app for the given host (the port is ignored)
+func (m *Mux) Host(host string, app *App) {
+	m.hosts[strings.ToLower(host)] = app
+}
+
+// Prefix serves the app under the given path prefix ("/" can be used to serve an app for all the other requests)
+func (m *Mux) Prefix(prefix string, app *App) {
+	m.prefixes = append(m.prefixes, &prefixApp{strings.TrimRight(prefix, "/"), app})
+	sort.SliceStable(m.prefixes, func(i, j int) bool {
+		return len(m.prefixes[i].prefix) > len(m.prefixes[j].prefix)
+	})
+}
+
+// NewMuxFromDir creates an app for each sub-directory of `dir` that contains an `app.lua` file (or a `pages/`
+// directory, the app is then created in pages mode).
+//
+// Directories whose name looks like a host name (e.g. "example.com") are served for this host, the other ones are
+// served under the "/<name>" prefix. Each app config is a copy of `base` (which can be nil) with the `Path` set, the
+// `override` func (if not nil) is called to customize it.
+func NewMuxFromDir(dir string, base *Config, override func(name string, conf *Config)) (*Mux, error) {
+	m := NewMux()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		appPath := filepath.Join(dir, name)
+		conf := &Config{}
+		if base != nil {
+			*conf = *base
+		}
+		conf.Path = appPath
+
+		entrypoint := "app.lua"
+		if conf.Entrypoint != "" {
+			entrypoint = conf.Entrypoint
+		}
+		if _, err := os.Stat(filepath.Join(appPath, entrypoint)); err != nil {
+			if _, err := os.Stat(filepath.Join(appPath, "pages")); err != nil {
+				continue
+			}
+			conf.Pages = true
+		}
+
+		if override != nil {
+			override(name, conf)
+		}
+		app, err := NewApp(conf)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(name, ".") {
+			m.Host(name, app)
+		} else {
+			m.Prefix("/"+name, app)
+		}
+	}
+	return m, nil
+}
+
+// ServeHTTP implements the `http.Handler` interface.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if app, ok := m.hosts[strings.ToLower(host)]; ok {
+		app.ServeHTTP(w, r)
+		return
+	}
+
+	for _, pa := range m.prefixes {
+		if pa.prefix != "" && r.URL.Path != pa.prefix && !strings.HasPrefix(r.URL.Path, pa.prefix+"/") {
+			continue
+		}
+		// Strip the prefix (like `http.StripPrefix`) and keep track of it
+		r2 := r.WithContext(context.WithValue(r.Context(), scriptRootKey{}, scriptRoot(r)+pa.prefix))
+		u := *r.URL
+		r2.URL = &u
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, pa.prefix)
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, pa.prefix)
+		}
+		pa.app.ServeHTTP(w, r2)
+		return
+	}
+
+	http.NotFound(w, r)
+}
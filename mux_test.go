@@ -0,0 +1,73 @@
+package gluapp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	mux, err := NewMuxFromDir("tests_data", nil, func(name string, conf *Config) {
+		if name == "app" {
+			conf.OpenAPIPath = "/openapi.json"
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	hostApp, err := NewApp(&Config{Path: "tests_data/app/"})
+	if err != nil {
+		panic(err)
+	}
+	mux.Host("example.com", hostApp)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testData := []struct {
+		host                       string
+		path                       string
+		expectedResponseBody       string
+		expectedResponseStatusCode int
+	}{
+		{"", "/app/", "hello app", 200},
+		{"", "/app", "hello app", 200},
+		{"", "/app/bar", "bar", 200},
+		{"", "/app/lol.html", "lol\n", 200},
+		// Ensure the script root is used to build URLs
+		{"", "/app/assets_urls", "/app/lol.a4244aa4.html\n<script src=\"/app/js/app.f9444510.js\"></script>\n", 200},
+		// Ensure the config override is applied
+		{"", "/app/openapi.json", "", 200},
+		{"", "/pages_app/blog/hello", "post hello", 200},
+		{"", "/pages_app/openapi.json", "Not Found", 404},
+		{"", "/appnope", "404 page not found\n", 404},
+		{"example.com", "/bar", "bar", 200},
+		{"example.com", "/assets_urls", "/lol.a4244aa4.html\n<script src=\"/js/app.f9444510.js\"></script>\n", 200},
+	}
+
+	for _, tdata := range testData {
+		req, err := http.NewRequest("GET", server.URL+tdata.path, nil)
+		if err != nil {
+			panic(err)
+		}
+		if tdata.host != "" {
+			req.Host = tdata.host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if resp.StatusCode != tdata.expectedResponseStatusCode {
+			t.Errorf("%s%s: bad status code, got %d, expected %d", tdata.host, tdata.path, resp.StatusCode, tdata.expectedResponseStatusCode)
+		}
+		if tdata.expectedResponseBody != "" && string(body) != tdata.expectedResponseBody {
+			t.Errorf("%s%s: bad body, got %q, expected %q", tdata.host, tdata.path, body, tdata.expectedResponseBody)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package gluapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The OpenAPI document is generated from the routes metadata, the following keys are supported:
+//
+//   router:get('/posts/:id', handler, {
+//     summary = 'Get a post',
+//     description = 'Returns a single post',
+//     operation_id = 'getPost',
+//     tags = {'posts'},
+//     parameters = {{name = 'full', ['in'] = 'query', schema = {type = 'boolean'}}},
+//     request_body = {schema = {type = 'object'}, content_type = 'application/json'},
+//     responses = {['200'] = {description = 'The post', schema = {type = 'object'}}},
+//     openapi = false, -- exclude the route from the document
+//   })
+//
+// Path parameters (`:id`) are automatically documented as required strings if not declared, and the pages are
+// documented for every method.
+
+const openAPIVersion = "3.0.3"
+
+// Methods that can be documented in an OpenAPI path item
+var openAPIMethods = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true, "OPTIONS": true, "HEAD": true, "PATCH": true, "TRACE": true,
+}
+
+// BuildOpenAPI generates an OpenAPI 3 JSON document from the given routes (as returned by `App.Routes`).
+func BuildOpenAPI(title, version string, routes []*Route) ([]byte, error) {
+	paths := map[string]interface{}{}
+	for _, rt := range routes {
+		if rt.ShadowedBy != nil {
+			continue
+		}
+		if enabled, ok := rt.Meta["openapi"].(bool); ok && !enabled {
+			continue
+		}
+		// The pages handle every method
+		routeMethods := []string{rt.Method}
+		if rt.Method == any {
+			routeMethods = methods
+		}
+		p, params := openAPIPath(rt.Path)
+		for _, method := range routeMethods {
+			if !openAPIMethods[method] {
+				continue
+			}
+			item, ok := paths[p].(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{}
+				paths[p] = item
+			}
+			item[strings.ToLower(method)] = openAPIOperation(rt, params)
+		}
+	}
+
+	if title == "" {
+		title = "gluapp"
+	}
+	if version == "" {
+		version = "0.0.0"
+	}
+	doc := map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// OpenAPI loads the app and returns the generated OpenAPI document, it's only generated on the first (successful)
+// call.
+func (a *App) OpenAPI() ([]byte, error) {
+	a.openAPIMu.Lock()
+	defer a.openAPIMu.Unlock()
+	if a.openAPIDoc != nil {
+		return a.openAPIDoc, nil
+	}
+	routes, err := a.Routes()
+	if err != nil {
+		return nil, err
+	}
+	js, err := BuildOpenAPI(a.conf.OpenAPITitle, a.conf.OpenAPIVersion, routes)
+	if err != nil {
+		return nil, err
+	}
+	a.openAPIDoc = js
+	return js, nil
+}
+
+// serveOpenAPI returns a response containing the OpenAPI document
+func (a *App) serveOpenAPI(r *http.Request) (*Response, error) {
+	js, err := a.OpenAPI()
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		Body:       js,
+		Header:     http.Header{},
+		StatusCode: 200,
+		req:        r,
+	}
+	resp.Header.Set("Content-Type", "application/json")
+	return resp, nil
+}
+
+// openAPIPath converts a router path (`/hello/:name`) to an OpenAPI path (`/hello/{name}`) and returns the named
+// parameters.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func openAPIOperation(rt *Route, pathParams []string) map[string]interface{} {
+	op := map[string]interface{}{}
+	for _, k := range []string{"summary", "description", "tags"} {
+		if v, ok := rt.Meta[k]; ok {
+			op[k] = v
+		}
+	}
+	if v, ok := rt.Meta["operation_id"]; ok {
+		op["operationId"] = v
+	}
+
+	// Parameters
+	var parameters []interface{}
+	declared := map[string]bool{}
+	for _, v := range toSlice(rt.Meta["parameters"]) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Copy the parameter, the route metadata must not be modified
+		param := map[string]interface{}{}
+		for k, v := range m {
+			param[k] = v
+		}
+		if param["in"] == "path" {
+			param["required"] = true
+			declared[fmt.Sprintf("%v", param["name"])] = true
+		}
+		parameters = append(parameters, param)
+	}
+	for _, name := range pathParams {
+		if declared[name] {
+			continue
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	// Request body
+	if rb, ok := rt.Meta["request_body"].(map[string]interface{}); ok {
+		requestBody := openAPIContent(rb)
+		if v, ok := rb["required"]; ok {
+			requestBody["required"] = v
+		}
+		op["requestBody"] = requestBody
+	}
+
+	// Responses
+	responses := map[string]interface{}{}
+	for code, v := range toMap(rt.Meta["responses"]) {
+		r, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		response := openAPIContent(r)
+		description, ok := r["description"]
+		if !ok {
+			description = http.StatusText(toStatusCode(code))
+		}
+		response["description"] = description
+		responses[code] = response
+	}
+	if len(responses) == 0 {
+		responses["200"] = map[string]interface{}{"description": http.StatusText(http.StatusOK)}
+	}
+	op["responses"] = responses
+
+	return op
+}
+
+// openAPIContent builds an object with a `content` key from a `{schema=, content_type=}` table
+func openAPIContent(m map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	if v, ok := m["description"]; ok {
+		out["description"] = v
+	}
+	if schema, ok := m["schema"]; ok {
+		contentType := "application/json"
+		if ct, ok := m["content_type"].(string); ok {
+			contentType = ct
+		}
+		out["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": schema},
+		}
+	}
+	return out
+}
+
+func toStatusCode(code string) int {
+	var statusCode int
+	fmt.Sscanf(code, "%d", &statusCode)
+	return statusCode
+}
+
+// toSlice converts a Lua array (as converted by luautil) to a slice
+func toSlice(v interface{}) []interface{} {
+	if s, ok := v.([]interface{}); ok {
+		return s
+	}
+	return nil
+}
+
+// toMap converts a Lua table (as converted by luautil) to a map with string keys
+func toMap(v interface{}) map[string]interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for k, v := range m {
+			out[fmt.Sprintf("%v", k)] = v
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package gluapp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestOpenAPI(t *testing.T) {
+	var loads int
+	app, err := NewApp(&Config{Path: "tests_data/app/", OpenAPIPath: "/openapi.json", OpenAPITitle: "Test app",
+		SetupState: func(L *lua.LState, w http.ResponseWriter, r *http.Request) error {
+			loads++
+			return nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/openapi.json")
+	if err != nil {
+		panic(err)
+	}
+	js, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("bad status code, got %d, expected 200", resp.StatusCode)
+	}
+
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(js, &doc); err != nil {
+		panic(err)
+	}
+	if doc["openapi"] != openAPIVersion {
+		t.Errorf("bad openapi version, got %v", doc["openapi"])
+	}
+	if title := doc["info"].(map[string]interface{})["title"]; title != "Test app" {
+		t.Errorf("bad title, got %v, expected \"Test app\"", title)
+	}
+	paths := doc["paths"].(map[string]interface{})
+	if len(paths) != 5 {
+		t.Errorf("bad paths count, got %d, expected 5", len(paths))
+	}
+	post, ok := paths["/posts/{id}"].(map[string]interface{})["post"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("missing POST /posts/{id} operation")
+	}
+	if post["summary"] != "Update a post" {
+		t.Errorf("bad summary, got %v", post["summary"])
+	}
+	param := post["parameters"].([]interface{})[0].(map[string]interface{})
+	if param["name"] != "id" || param["in"] != "path" || param["required"] != true {
+		t.Errorf("bad path parameter, got %+v", param)
+	}
+	if _, ok := post["requestBody"].(map[string]interface{})["content"]; !ok {
+		t.Errorf("missing request body content")
+	}
+	if _, ok := post["responses"].(map[string]interface{})["200"]; !ok {
+		t.Errorf("missing 200 response")
+	}
+
+	// Ensure the Go API returns the same document
+	js2, err := app.OpenAPI()
+	if err != nil {
+		panic(err)
+	}
+	if string(js) != string(js2) {
+		t.Errorf("documents differ")
+	}
+	// The app is only loaded once to generate the document
+	if loads != 1 {
+		t.Errorf("the app should be loaded once, got %d", loads)
+	}
+}
+
+func TestOpenAPIPages(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/pages_app/", Pages: true})
+	if err != nil {
+		panic(err)
+	}
+	js, err := app.OpenAPI()
+	if err != nil {
+		panic(err)
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(js, &doc); err != nil {
+		panic(err)
+	}
+
+	// The pages are documented for every method
+	item, ok := doc["paths"].(map[string]interface{})["/blog/{slug}"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("missing /blog/{slug} path")
+	}
+	for _, method := range []string{"get", "post", "delete"} {
+		if _, ok := item[method]; !ok {
+			t.Errorf("missing %s operation", method)
+		}
+	}
+	if _, ok := item["connect"]; ok {
+		t.Errorf("unexpected connect operation")
+	}
+	param := item["get"].(map[string]interface{})["parameters"].([]interface{})[0].(map[string]interface{})
+	if param["name"] != "slug" || param["in"] != "path" {
+		t.Errorf("bad path parameter, got %+v", param)
+	}
+}
+
+func TestOpenAPIOperationKeepsMeta(t *testing.T) {
+	param := map[string]interface{}{"name": "id", "in": "path"}
+	rt := &Route{Method: "GET", Path: "/users/:id", Meta: map[string]interface{}{
+		"parameters": []interface{}{param},
+	}}
+	op := openAPIOperation(rt, []string{"id"})
+	if p := op["parameters"].([]interface{})[0].(map[string]interface{}); p["required"] != true {
+		t.Errorf("the path parameter should be required, got %+v", p)
+	}
+	if _, ok := param["required"]; ok || len(param) != 2 {
+		t.Errorf("the route metadata should not be modified, got %+v", param)
+	}
+}
@@ -0,0 +1,79 @@
+package gluapp
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pagePath converts a file path (slash-separated) relative to the `pages/` directory to a router path, e.g.:
+// "blog/index.lua" => "/blog", "blog/[slug].lua" => "/blog/:slug"
+func pagePath(rel string) string {
+	parts := strings.Split(strings.TrimSuffix(rel, ".lua"), "/")
+	if parts[len(parts)-1] == "index" {
+		parts = parts[:len(parts)-1]
+	}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "[") && strings.HasSuffix(part, "]") {
+			parts[i] = ":" + part[1:len(part)-1]
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// buildPagesIndex walks the `pages/` directory and returns a router where each route data is the path of the Lua
+// file to execute.
+//
+// Files and directories starting with "_" are skipped (so they can be used as modules), static paths are matched
+// before the ones with named parameters. The paths are normalized according to `pathPolicy` (`redirect` if empty).
+func buildPagesIndex(fsys fs.FS, pagesPath, pathPolicy string) (*router, error) {
+	switch pathPolicy {
+	case "":
+		pathPolicy = pathPolicyRedirect
+	case pathPolicyStrict, pathPolicyRedirect, pathPolicyLenient:
+	default:
+		return nil, fmt.Errorf("unknown pages path policy %q", pathPolicy)
+	}
+
+	pages := map[string]string{}
+	var paths []string
+	if err := fs.WalkDir(fsys, pagesPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), "_") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".lua") {
+			pp := pagePath(strings.TrimPrefix(p, pagesPath+"/"))
+			pages[pp] = p
+			paths = append(paths, pp)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		pi, pj := strings.Count(paths[i], ":"), strings.Count(paths[j], ":")
+		if pi != pj {
+			return pi < pj
+		}
+		return paths[i] < paths[j]
+	})
+
+	index := &router{routes: []*route{}, pathPolicy: pathPolicy}
+	for _, p := range paths {
+		rt := index.add(any, p, pages[p])
+		// Unlike the Lua router, the whole path must match
+		if rt.regexp != nil {
+			rt.regexp = regexp.MustCompile("^" + rt.regexp.String() + "$")
+		}
+	}
+	return index, nil
+}
@@ -0,0 +1,124 @@
+package gluapp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// reverseProxy holds the settings of `app.response:proxy(url, opts)`, the request is forwarded to the upstream (and
+// the response streamed back) when the response is written, since the Lua state is closed at this point, the hooks
+// are declarative:
+//
+//	app.response:proxy('http://legacy:8080', {
+//	  path = '/new/path',                -- replace the path
+//	  strip_prefix = '/legacy',          -- or strip a prefix from the request path
+//	  headers = {['X-From'] = 'gluapp'}, -- headers to set on the upstream request
+//	  remove_headers = {'Cookie'},       -- headers to remove from the upstream request
+//	  preserve_host = true,              -- keep the original `Host` header
+//	})
+//
+// Headers set on `app.response` are applied to the proxied response.
+type reverseProxy struct {
+	target        *url.URL
+	path          string
+	stripPrefix   string
+	header        http.Header
+	removeHeaders []string
+	preserveHost  bool
+}
+
+func responseProxy(L *lua.LState) int {
+	resp := checkResponse(L)
+	if resp == nil {
+		return 0
+	}
+	target, err := url.Parse(L.CheckString(2))
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		L.ArgError(2, "invalid upstream URL")
+	}
+	p := &reverseProxy{
+		target: target,
+		header: http.Header{},
+	}
+	if opts := L.OptTable(3, nil); opts != nil {
+		p.path = lua.LVAsString(opts.RawGetString("path"))
+		p.stripPrefix = lua.LVAsString(opts.RawGetString("strip_prefix"))
+		p.preserveHost = lua.LVAsBool(opts.RawGetString("preserve_host"))
+		if headers, ok := opts.RawGetString("headers").(*lua.LTable); ok {
+			headers.ForEach(func(k, v lua.LValue) {
+				p.header.Set(k.String(), v.String())
+			})
+		}
+		if headers, ok := opts.RawGetString("remove_headers").(*lua.LTable); ok {
+			headers.ForEach(func(_, v lua.LValue) {
+				p.removeHeaders = append(p.removeHeaders, v.String())
+			})
+		}
+	}
+	resp.proxy = p
+	return 0
+}
+
+// singleJoiningSlash joins the upstream path and the request path
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// serve forwards the request to the upstream, the body is provided since the original one has already been consumed
+func (p *reverseProxy) serve(w http.ResponseWriter, r *http.Request, body []byte, header http.Header) {
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			path := req.URL.Path
+			if p.path != "" {
+				path = p.path
+			} else if p.stripPrefix != "" {
+				path = strings.TrimPrefix(path, p.stripPrefix)
+			}
+			req.URL.Scheme = p.target.Scheme
+			req.URL.Host = p.target.Host
+			req.URL.Path = singleJoiningSlash(p.target.Path, path)
+			req.URL.RawPath = ""
+			if p.target.RawQuery == "" || req.URL.RawQuery == "" {
+				req.URL.RawQuery = p.target.RawQuery + req.URL.RawQuery
+			} else {
+				req.URL.RawQuery = p.target.RawQuery + "&" + req.URL.RawQuery
+			}
+			if !p.preserveHost {
+				req.Host = p.target.Host
+			}
+			for k, vs := range p.header {
+				req.Header[k] = vs
+			}
+			for _, k := range p.removeHeaders {
+				req.Header.Del(k)
+			}
+		},
+		ModifyResponse: func(res *http.Response) error {
+			for k, vs := range header {
+				res.Header[k] = vs
+			}
+			return nil
+		},
+		// Flush immediately to support streaming responses
+		FlushInterval: -1,
+	}
+
+	outreq := r.Clone(r.Context())
+	outreq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	outreq.ContentLength = int64(len(body))
+	rp.ServeHTTP(w, outreq)
+}
@@ -0,0 +1,78 @@
+package gluapp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+var testAppProxy = `
+local router = require('router').new()
+router:any('/legacy/:path', function(params)
+  app.response:headers():set('X-Proxied', 'yes')
+  app.response:proxy(upstream_url .. '/api', {
+    strip_prefix = '/legacy',
+    headers = {['X-From'] = 'gluapp'},
+    remove_headers = {'X-Secret'},
+  })
+end)
+router:run()
+`
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(201)
+		fmt.Fprintf(w, "%s %s?%s from=%s secret=%s body=%s", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("X-From"), r.Header.Get("X-Secret"), body)
+	}))
+	defer upstream.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := Exec(&Config{
+			SetupState: func(L *lua.LState, w http.ResponseWriter, r *http.Request) error {
+				L.SetGlobal("upstream_url", lua.LString(upstream.URL))
+				return nil
+			},
+		}, testAppProxy, w, r); err != nil {
+			panic(err)
+		}
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest("POST", server.URL+"/legacy/posts?page=2", strings.NewReader("hello"))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("X-Secret", "s3cr3t")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	if resp.StatusCode != 201 {
+		t.Errorf("bad status code, got %d, expected 201", resp.StatusCode)
+	}
+	expected := "POST /api/posts?page=2 from=gluapp secret= body=hello"
+	if string(body) != expected {
+		t.Errorf("bad body, got %q, expected %q", body, expected)
+	}
+	for _, h := range []string{"X-Upstream", "X-Proxied"} {
+		if len(resp.Header[h]) != 1 {
+			t.Errorf("bad header %s, got %v", h, resp.Header[h])
+		}
+	}
+}
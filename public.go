@@ -0,0 +1,233 @@
+package gluapp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Content types that are worth compressing on the fly
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// publicFile represents an indexed file from the public/ directory
+type publicFile struct {
+	path    string // Path in the app filesystem
+	hash    string // Hex-encoded SHA256 of the content
+	size    int64
+	modTime time.Time
+
+	// Path of the precompressed siblings if any (`.gz`/`.br` files)
+	gzPath, brPath string
+}
+
+// etag returns the strong ETag of the file, the variant (encoding/minification) is appended as each representation
+// needs its own ETag
+func (f *publicFile) etag(variant string) string {
+	if variant != "" {
+		return fmt.Sprintf("\"%s-%s\"", f.hash[:32], variant)
+	}
+	return fmt.Sprintf("\"%s\"", f.hash[:32])
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// buildPublicIndex walks the public directory and returns the index of files keyed by URL path
+func buildPublicIndex(fsys fs.FS, publicPath string) (map[string]*publicFile, error) {
+	index := map[string]*publicFile{}
+	if err := fs.WalkDir(fsys, publicPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			f, err := d.Info()
+			if err != nil {
+				return err
+			}
+			hash, err := hashFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			index[strings.TrimPrefix(p, publicPath)] = &publicFile{
+				path:    p,
+				hash:    hash,
+				size:    f.Size(),
+				modTime: f.ModTime(),
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Attach the precompressed siblings to the original files (they can still be requested directly)
+	for p, f := range index {
+		switch path.Ext(p) {
+		case ".gz":
+			if orig, ok := index[strings.TrimSuffix(p, ".gz")]; ok {
+				orig.gzPath = f.path
+			}
+		case ".br":
+			if orig, ok := index[strings.TrimSuffix(p, ".br")]; ok {
+				orig.brPath = f.path
+			}
+		}
+	}
+
+	return index, nil
+}
+
+// acceptsEncoding returns true if the request `Accept-Encoding` header contains the given encoding (and it's not
+// explicitly refused with `q=0`)
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") && strings.Trim(param[2:], "0.") == "" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isCompressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// servePublic serves a file from the public/ directory, with the ETag/cache headers set, a precompressed version is
+// served if available and on-the-fly gzip compression is used if enabled.
+//
+// Fingerprinted assets are served with `immutable` cache headers.
+func (a *App) servePublic(w http.ResponseWriter, r *http.Request, urlPath string, f *publicFile, immutable bool) {
+	ext := path.Ext(urlPath)
+	contentType := mime.TypeByExtension(ext)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if immutable {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else if cacheControl, ok := a.conf.PublicCacheControl[ext]; ok {
+		w.Header().Set("Cache-Control", cacheControl)
+	} else if cacheControl, ok := a.conf.PublicCacheControl[""]; ok {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+
+	// The precompressed versions are not minified, so they're not used if the file must be minified
+	var minify func(string) string
+	if a.conf.Minify {
+		minify = minifyContentType(contentType)
+	}
+	precompressed := minify == nil && (f.gzPath != "" || f.brPath != "")
+
+	gzipOnTheFly := a.conf.PublicGzipMinSize > 0 && f.size >= a.conf.PublicGzipMinSize && isCompressible(contentType)
+	if precompressed || gzipOnTheFly {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	// Serve the precompressed version if any
+	for _, variant := range []struct{ encoding, path string }{{"br", f.brPath}, {"gzip", f.gzPath}} {
+		if !precompressed || variant.path == "" || !acceptsEncoding(r, variant.encoding) {
+			continue
+		}
+		cf, err := openSeeker(a.fsys, variant.path)
+		if err != nil {
+			continue
+		}
+		defer cf.Close()
+		w.Header().Set("Content-Encoding", variant.encoding)
+		w.Header().Set("ETag", f.etag(variant.encoding))
+		http.ServeContent(w, r, urlPath, f.modTime, cf)
+		return
+	}
+
+	of, err := openSeeker(a.fsys, f.path)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer of.Close()
+
+	var content io.ReadSeeker = of
+	var variant string
+
+	// Minify the file if enabled
+	if minify != nil {
+		data, err := a.minified(f, contentType, minify)
+		if err != nil {
+			panic(err)
+		}
+		content = bytes.NewReader(data)
+		variant = "min"
+	}
+
+	// Compress on the fly
+	if gzipOnTheFly && acceptsEncoding(r, "gzip") {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := io.Copy(gw, content); err != nil {
+			panic(err)
+		}
+		if err := gw.Close(); err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		content = bytes.NewReader(buf.Bytes())
+		variant = strings.TrimPrefix(variant+"-gzip", "-")
+	}
+
+	w.Header().Set("ETag", f.etag(variant))
+	http.ServeContent(w, r, urlPath, f.modTime, content)
+}
+
+// minified returns the minified content of the file, the result is cached in memory (keyed by the content hash and
+// the content type, as the same content may be minified differently)
+func (a *App) minified(f *publicFile, contentType string, minify func(string) string) ([]byte, error) {
+	key := f.hash + " " + contentType
+	a.minifyMu.Lock()
+	defer a.minifyMu.Unlock()
+	if data, ok := a.minifyCache[key]; ok {
+		return data, nil
+	}
+	raw, err := fs.ReadFile(a.fsys, f.path)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(minify(string(raw)))
+	a.minifyCache[key] = data
+	return data, nil
+}
@@ -0,0 +1,196 @@
+package gluapp
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublic(t *testing.T) {
+	app, err := NewApp(&Config{
+		Path:               "tests_data/app/",
+		PublicCacheControl: map[string]string{".js": "public, max-age=3600", "": "no-cache"},
+		PublicGzipMinSize:  1,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	// Disable the transparent decompression to check the raw responses
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	testData := []struct {
+		path, acceptEncoding                   string
+		expectedEncoding, expectedCacheControl string
+		expectedResponseBody                   string
+	}{
+		{"/js/app.js", "", "", "public, max-age=3600", "console.log(\"hello\");\n"},
+		{"/js/app.js", "gzip, deflate", "gzip", "public, max-age=3600", "console.log(\"hello\");\n"},
+		{"/js/app.js", "gzip;q=0", "", "public, max-age=3600", "console.log(\"hello\");\n"},
+		{"/lol.html", "", "", "no-cache", "lol\n"},
+		{"/lol.html", "gzip", "gzip", "no-cache", "lol\n"},
+	}
+
+	for _, tdata := range testData {
+		req, err := http.NewRequest("GET", server.URL+tdata.path, nil)
+		if err != nil {
+			panic(err)
+		}
+		if tdata.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tdata.acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		var body []byte
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				panic(err)
+			}
+			body, err = ioutil.ReadAll(gr)
+			if err != nil {
+				panic(err)
+			}
+		} else {
+			body, err = ioutil.ReadAll(resp.Body)
+			if err != nil {
+				panic(err)
+			}
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: bad status code, got %d, expected 200", tdata.path, resp.StatusCode)
+		}
+		if string(body) != tdata.expectedResponseBody {
+			t.Errorf("%s: bad body, got %q, expected %q", tdata.path, body, tdata.expectedResponseBody)
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != tdata.expectedEncoding {
+			t.Errorf("%s: bad encoding, got %q, expected %q", tdata.path, enc, tdata.expectedEncoding)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != tdata.expectedCacheControl {
+			t.Errorf("%s: bad cache control, got %q, expected %q", tdata.path, cc, tdata.expectedCacheControl)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != mime.TypeByExtension(filepath.Ext(tdata.path)) {
+			t.Errorf("%s: bad content type, got %q", tdata.path, ct)
+		}
+
+		// Ensure the ETag is supported
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			t.Errorf("%s: missing ETag", tdata.path)
+			continue
+		}
+		req.Header.Set("If-None-Match", etag)
+		resp, err = client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("%s: bad status code, got %d, expected 304", tdata.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestAssets(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/app/"})
+	if err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	testData := []struct {
+		path                       string
+		expectedResponseBody       string
+		expectedCacheControl       string
+		expectedResponseStatusCode int
+	}{
+		{"/assets_urls", "/lol.a4244aa4.html\n<script src=\"/js/app.f9444510.js\"></script>\n", "", 200},
+		{"/lol.a4244aa4.html", "lol\n", immutableCacheControl, 200},
+		{"/js/app.f9444510.js", "console.log(\"hello\");\n", immutableCacheControl, 200},
+		{"/lol.html", "lol\n", "", 200},
+		{"/lol.00000000.html", "Not Found", "", 404},
+	}
+
+	for _, tdata := range testData {
+		resp, err := http.Get(server.URL + tdata.path)
+		if err != nil {
+			panic(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if resp.StatusCode != tdata.expectedResponseStatusCode {
+			t.Errorf("%s: bad status code, got %d, expected %d", tdata.path, resp.StatusCode, tdata.expectedResponseStatusCode)
+		}
+		if string(body) != tdata.expectedResponseBody {
+			t.Errorf("%s: bad body, got %q, expected %q", tdata.path, body, tdata.expectedResponseBody)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != tdata.expectedCacheControl {
+			t.Errorf("%s: bad cache control, got %q, expected %q", tdata.path, cc, tdata.expectedCacheControl)
+		}
+	}
+}
+
+func TestPublicMinify(t *testing.T) {
+	app, err := NewApp(&Config{Path: "tests_data/app/", Minify: true})
+	if err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	// The precompressed version is not minified, so it must not be served
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	for _, path := range []string{"/js/app.js", "/js/app.f9444510.js"} {
+		for _, encoding := range []string{"", "gzip"} {
+			req, err := http.NewRequest("GET", server.URL+path, nil)
+			if err != nil {
+				panic(err)
+			}
+			if encoding != "" {
+				req.Header.Set("Accept-Encoding", encoding)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				panic(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				panic(err)
+			}
+			if string(body) != "console.log(\"hello\");" || resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("%s (%q): bad body, got %q", path, encoding, body)
+			}
+		}
+	}
+
+	// The cache is keyed by content type too
+	f := app.publicIndex["/js/app.js"]
+	if _, ok := app.minifyCache[f.hash+" "+mime.TypeByExtension(".js")]; !ok {
+		t.Errorf("minified content not cached")
+	}
+	data, err := app.minified(f, "text/html", func(string) string { return "html" })
+	if err != nil {
+		panic(err)
+	}
+	if string(data) != "html" {
+		t.Errorf("minified content shared between content types, got %q", data)
+	}
+}
@@ -0,0 +1,430 @@
+package gluapp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/yuin/gopher-lua"
+)
+
+// The `redis` module is a client for the Redis server configured in `Config.Redis`:
+//
+//	local r = require('redis').new()
+//	r:set('key', 'value', 'EX', 60)
+//	local n = r:incr('hits')
+//	local user = r:hgetall('user:1') -- {name = 'thomas'}
+//	local res = r:pipeline(function(p)
+//	  p:incr('a')
+//	  p:incr('b')
+//	end) -- {1, 1}
+//	r:subscribe({'events'}, function(channel, message)
+//	  -- return false to stop
+//	end)
+//
+// Table arguments are flattened (`r:hmset('user:1', {name = 'thomas'})`), replies are converted to Lua values (nil,
+// numbers, strings and arrays), errors returned by the server are raised.
+
+// Commands exposed as methods (`r:command(name, ...)` can be used for the other ones)
+var redisCommands = []string{
+	// Keys
+	"del", "exists", "expire", "pexpire", "ttl", "pttl", "keys", "type", "rename", "persist",
+	// Strings
+	"get", "set", "setnx", "setex", "getset", "mget", "mset", "incr", "incrby", "incrbyfloat", "decr", "decrby",
+	"append", "strlen",
+	// Hashes
+	"hget", "hset", "hsetnx", "hmget", "hmset", "hdel", "hexists", "hincrby", "hkeys", "hvals", "hlen", "hgetall",
+	// Lists
+	"lpush", "rpush", "lpop", "rpop", "llen", "lrange", "lindex", "lrem", "lset", "ltrim", "rpoplpush",
+	// Sets
+	"sadd", "srem", "smembers", "sismember", "scard",
+	// Sorted sets
+	"zadd", "zrem", "zscore", "zincrby", "zcard", "zrange", "zrevrange", "zrangebyscore",
+	// Pub/sub
+	"publish",
+}
+
+// RedisConfig represents the Redis server used by the `redis` module, the connection pool is shared by all the
+// requests.
+type RedisConfig struct {
+	// Address of the server (`host:port`)
+	Addr string
+
+	Password string
+	DB       int
+
+	// Connection pool settings (0 means no limit for `MaxActive`, and no timeout for `IdleTimeout`)
+	MaxIdle     int
+	MaxActive   int
+	IdleTimeout time.Duration
+
+	mu   sync.Mutex
+	pool *redis.Pool
+	refs int // Number of apps using the pool
+}
+
+// getPool returns the connection pool, creating it on first use
+func (c *RedisConfig) getPool() *redis.Pool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool == nil {
+		maxIdle := c.MaxIdle
+		if maxIdle == 0 {
+			maxIdle = 10
+		}
+		c.pool = &redis.Pool{
+			MaxIdle:     maxIdle,
+			MaxActive:   c.MaxActive,
+			IdleTimeout: c.IdleTimeout,
+			Wait:        c.MaxActive > 0,
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", c.Addr, redis.DialPassword(c.Password), redis.DialDatabase(c.DB))
+			},
+		}
+	}
+	return c.pool
+}
+
+// Close closes the connection pool.
+func (c *RedisConfig) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.close()
+}
+
+func (c *RedisConfig) close() error {
+	if c.pool == nil {
+		return nil
+	}
+	err := c.pool.Close()
+	c.pool = nil
+	return err
+}
+
+// acquire registers an app using the pool
+func (c *RedisConfig) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs++
+}
+
+// release closes the pool once no app is using it (the config may be shared, e.g. by the apps of a `Mux`)
+func (c *RedisConfig) release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs--; c.refs > 0 {
+		return nil
+	}
+	return c.close()
+}
+
+// redisClient is the `redis` user-defined type
+type redisClient struct {
+	ctx  context.Context
+	pool *redis.Pool
+}
+
+// redisPipeline is the `redis_pipeline` user-defined type, commands are queued until the callback returns
+type redisPipeline struct {
+	conn redis.Conn
+	cmds []string // Queued commands (empty for the ones sent with `command`)
+}
+
+func checkRedisClient(L *lua.LState) *redisClient {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value.(*redisClient); ok {
+		return v
+	}
+	L.ArgError(1, "redis expected")
+	return nil
+}
+
+func checkRedisPipeline(L *lua.LState) *redisPipeline {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value.(*redisPipeline); ok {
+		return v
+	}
+	L.ArgError(1, "pipeline expected")
+	return nil
+}
+
+// redisArg converts a Lua value to a command argument
+func redisArg(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LNumber:
+		if float64(v) == float64(int64(v)) {
+			return int64(v)
+		}
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case lua.LBool:
+		if v {
+			return 1
+		}
+		return 0
+	default:
+		return v.String()
+	}
+}
+
+// redisArgs converts the arguments (starting at index `n`) to command arguments, tables are flattened
+func redisArgs(L *lua.LState, n int) []interface{} {
+	var args []interface{}
+	for i := n; i <= L.GetTop(); i++ {
+		tbl, ok := L.Get(i).(*lua.LTable)
+		if !ok {
+			args = append(args, redisArg(L.Get(i)))
+			continue
+		}
+		// Arrays are appended as is, and maps as key/value pairs (sorted by key)
+		if tbl.Len() > 0 {
+			tbl.ForEach(func(_, v lua.LValue) {
+				args = append(args, redisArg(v))
+			})
+			continue
+		}
+		var keys []string
+		tbl.ForEach(func(k, _ lua.LValue) {
+			keys = append(keys, k.String())
+		})
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, k, redisArg(tbl.RawGetString(k)))
+		}
+	}
+	return args
+}
+
+// redisReply converts a reply to a Lua value
+func redisReply(L *lua.LState, reply interface{}) lua.LValue {
+	switch reply := reply.(type) {
+	case nil:
+		return lua.LNil
+	case int64:
+		return lua.LNumber(reply)
+	case []byte:
+		return lua.LString(reply)
+	case string:
+		return lua.LString(reply)
+	case []interface{}:
+		tbl := L.CreateTable(len(reply), 0)
+		for _, v := range reply {
+			tbl.Append(redisReply(L, v))
+		}
+		return tbl
+	case redis.Error:
+		L.RaiseError("redis: %v", reply)
+	}
+	return lua.LString(fmt.Sprintf("%v", reply))
+}
+
+// redisHashReply converts an `HGETALL` reply (a list of field/value pairs) to a table
+func redisHashReply(L *lua.LState, reply interface{}) lua.LValue {
+	values, err := redis.StringMap(reply, nil)
+	if err != nil {
+		L.RaiseError("redis: %v", err)
+	}
+	tbl := L.CreateTable(0, len(values))
+	for k, v := range values {
+		tbl.RawSetString(k, lua.LString(v))
+	}
+	return tbl
+}
+
+// redisCommandReply converts the reply of a command method (the `HGETALL` replies are returned as tables)
+func redisCommandReply(L *lua.LState, cmd string, reply interface{}) lua.LValue {
+	if cmd == "HGETALL" {
+		return redisHashReply(L, reply)
+	}
+	return redisReply(L, reply)
+}
+
+// do executes the command with a connection from the pool
+func (c *redisClient) do(L *lua.LState, cmd string, args []interface{}) interface{} {
+	conn, err := c.pool.GetContext(c.ctx)
+	if err != nil {
+		L.RaiseError("redis: %v", err)
+	}
+	defer conn.Close()
+	reply, err := conn.Do(cmd, args...)
+	if err != nil {
+		L.RaiseError("redis: %v", err)
+	}
+	return reply
+}
+
+// subscribe calls the callback for each message until it returns false, or the context is canceled
+func (c *redisClient) subscribe(L *lua.LState) int {
+	// The callback is the last argument
+	if L.GetTop() < 3 {
+		L.ArgError(2, "at least one channel and a callback expected")
+	}
+	fn := L.CheckFunction(L.GetTop())
+	channels := redisArgs(L, 2)
+	channels = channels[:len(channels)-1]
+	if len(channels) == 0 {
+		L.ArgError(2, "at least one channel expected")
+	}
+
+	conn, err := c.pool.GetContext(c.ctx)
+	if err != nil {
+		L.RaiseError("redis: %v", err)
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(channels...); err != nil {
+		L.RaiseError("redis: %v", err)
+	}
+
+	// Unsubscribe when the request is canceled (receiving while sending is fine, but not sending concurrently)
+	var mu sync.Mutex
+	unsubscribe := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return psc.Unsubscribe()
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-c.ctx.Done():
+			unsubscribe()
+		case <-done:
+		}
+	}()
+	// The goroutine must be done with the connection before it's closed
+	defer func() {
+		close(done)
+		<-exited
+	}()
+
+	var stopped bool
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			if stopped {
+				continue
+			}
+			L.Push(fn)
+			L.Push(lua.LString(v.Channel))
+			L.Push(lua.LString(v.Data))
+			L.Call(2, 1)
+			ret := L.Get(-1)
+			L.Pop(1)
+			if ret == lua.LFalse {
+				stopped = true
+				if err := unsubscribe(); err != nil {
+					L.RaiseError("redis: %v", err)
+				}
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return 0
+			}
+		case error:
+			if c.ctx.Err() != nil {
+				return 0
+			}
+			L.RaiseError("redis: %v", v)
+		}
+	}
+}
+
+func setupRedis(ctx context.Context, conf *RedisConfig) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		clientMethods := map[string]lua.LGFunction{
+			"command": func(L *lua.LState) int {
+				c := checkRedisClient(L)
+				L.Push(redisReply(L, c.do(L, L.CheckString(2), redisArgs(L, 3))))
+				return 1
+			},
+			"pipeline": func(L *lua.LState) int {
+				c := checkRedisClient(L)
+				fn := L.CheckFunction(2)
+				conn, err := c.pool.GetContext(c.ctx)
+				if err != nil {
+					L.RaiseError("redis: %v", err)
+				}
+				defer conn.Close()
+				p := &redisPipeline{conn: conn}
+				ud := L.NewUserData()
+				ud.Value = p
+				L.SetMetatable(ud, L.GetTypeMetatable("redis_pipeline"))
+				L.Push(fn)
+				L.Push(ud)
+				L.Call(1, 0)
+
+				if err := conn.Flush(); err != nil {
+					L.RaiseError("redis: %v", err)
+				}
+				res := L.CreateTable(len(p.cmds), 0)
+				for _, cmd := range p.cmds {
+					reply, err := conn.Receive()
+					if rerr, ok := err.(redis.Error); ok {
+						// Errors are returned in place of the reply, like with `redis-cli`
+						res.Append(lua.LString(rerr.Error()))
+						continue
+					}
+					if err != nil {
+						L.RaiseError("redis: %v", err)
+					}
+					res.Append(redisCommandReply(L, cmd, reply))
+				}
+				L.Push(res)
+				return 1
+			},
+			"subscribe": func(L *lua.LState) int {
+				return checkRedisClient(L).subscribe(L)
+			},
+		}
+		pipelineMethods := map[string]lua.LGFunction{
+			"command": func(L *lua.LState) int {
+				p := checkRedisPipeline(L)
+				if err := p.conn.Send(L.CheckString(2), redisArgs(L, 3)...); err != nil {
+					L.RaiseError("redis: %v", err)
+				}
+				p.cmds = append(p.cmds, "")
+				return 0
+			},
+		}
+		for _, name := range redisCommands {
+			cmd := strings.ToUpper(name)
+			clientMethods[name] = func(L *lua.LState) int {
+				c := checkRedisClient(L)
+				L.Push(redisCommandReply(L, cmd, c.do(L, cmd, redisArgs(L, 2))))
+				return 1
+			}
+			pipelineMethods[name] = func(L *lua.LState) int {
+				p := checkRedisPipeline(L)
+				if err := p.conn.Send(cmd, redisArgs(L, 2)...); err != nil {
+					L.RaiseError("redis: %v", err)
+				}
+				p.cmds = append(p.cmds, cmd)
+				return 0
+			}
+		}
+		mtClient := L.NewTypeMetatable("redis")
+		L.SetField(mtClient, "__index", L.SetFuncs(L.NewTable(), clientMethods))
+		mtPipeline := L.NewTypeMetatable("redis_pipeline")
+		L.SetField(mtPipeline, "__index", L.SetFuncs(L.NewTable(), pipelineMethods))
+
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"new": func(L *lua.LState) int {
+				if conf == nil {
+					L.RaiseError("redis is not configured")
+				}
+				ud := L.NewUserData()
+				ud.Value = &redisClient{ctx: ctx, pool: conf.getPool()}
+				L.SetMetatable(ud, L.GetTypeMetatable("redis"))
+				L.Push(ud)
+				return 1
+			},
+		})
+		L.Push(mod)
+		return 1
+	}
+}
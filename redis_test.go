@@ -0,0 +1,209 @@
+package gluapp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/yuin/gopher-lua"
+)
+
+func TestRedis(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+	conf := &Config{Redis: &RedisConfig{Addr: s.Addr()}}
+	defer conf.Redis.Close()
+
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(conf).With("redis").Install(L); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(`
+local r = require('redis').new()
+assert(r:get('nope') == nil)
+assert(r:set('key', 'value') == 'OK')
+assert(r:get('key') == 'value')
+assert(r:incr('hits') == 1)
+assert(r:incrby('hits', 10) == 11)
+assert(r:expire('key', 60) == 1)
+assert(r:ttl('key') == 60)
+assert(r:hmset('user:1', {name = 'thomas', lang = 'go'}) == 'OK')
+local user = r:hgetall('user:1')
+assert(user.name == 'thomas' and user.lang == 'go')
+assert(r:hincrby('user:1', 'visits', 2) == 2)
+assert(r:rpush('queue', {'a', 'b'}) == 2)
+assert(r:lpush('queue', 'c') == 3)
+local items = r:lrange('queue', 0, -1)
+assert(#items == 3 and items[1] == 'c' and items[3] == 'b')
+assert(r:lpop('queue') == 'c')
+assert(r:command('llen', 'queue') == 2)
+assert(r:del('key', 'hits') == 2)
+
+local ok, err
+local res = r:pipeline(function(p)
+  p:incr('a')
+  p:incr('a')
+  p:hget('user:1', 'name')
+  p:command('incr', 'user:1')
+  p:hgetall('user:1')
+end)
+assert(#res == 5 and res[1] == 1 and res[2] == 2 and res[3] == 'thomas')
+assert(string.find(res[4], 'WRONGTYPE'))
+assert(res[5].name == 'thomas' and res[5].lang == 'go')
+
+ok, err = pcall(function() r:subscribe() end)
+assert(not ok and string.find(tostring(err), 'at least one channel and a callback expected'))
+ok, err = pcall(function() r:subscribe({}, function() end) end)
+assert(not ok and string.find(tostring(err), 'at least one channel expected'))
+
+ok, err = pcall(function() r:incr('user:1') end)
+assert(not ok and string.find(tostring(err), 'WRONGTYPE'))
+`); err != nil {
+		t.Errorf("failed to execute the script: %v", err)
+	}
+	if v, _ := s.Get("a"); v != "2" {
+		t.Errorf("bad pipeline result, got %q", v)
+	}
+
+	// Not configured
+	L2 := lua.NewState()
+	defer L2.Close()
+	if err := NewStdlib(&Config{}).With("redis").Install(L2); err != nil {
+		panic(err)
+	}
+	if err := L2.DoString(`require('redis').new()`); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("bad error for missing config: %v", err)
+	}
+}
+
+// pubSubServer is a fake Redis server handling `SUBSCRIBE`/`UNSUBSCRIBE` (not supported by miniredis), it publishes
+// the messages once subscribed
+func pubSubServer(messages []string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					cmd, err := readRESPCommand(r)
+					if err != nil {
+						return
+					}
+					switch strings.ToUpper(cmd[0]) {
+					case "SUBSCRIBE":
+						for i, channel := range cmd[1:] {
+							fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, i+1)
+						}
+						for _, msg := range messages {
+							fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(cmd[1]), cmd[1],
+								len(msg), msg)
+						}
+					case "UNSUBSCRIBE":
+						fmt.Fprintf(conn, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
+					default:
+						fmt.Fprintf(conn, "-ERR unknown command\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+// readRESPCommand reads a command (an array of bulk strings)
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		return strings.TrimSuffix(line, "\r\n"), err
+	}
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(line, "*"))
+	if err != nil {
+		return nil, err
+	}
+	var cmd []string
+	for i := 0; i < n; i++ {
+		if _, err := readLine(); err != nil { // Length
+			return nil, err
+		}
+		arg, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		cmd = append(cmd, arg)
+	}
+	return cmd, nil
+}
+
+func TestRedisSubscribe(t *testing.T) {
+	l := pubSubServer([]string{"hello", "world", "stop"})
+	defer l.Close()
+	conf := &Config{Redis: &RedisConfig{Addr: l.Addr().String()}}
+	defer conf.Redis.Close()
+
+	// Stopped by the callback
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(conf).With("redis").Install(L); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(`
+local r = require('redis').new()
+local received = {}
+r:subscribe('events', function(channel, message)
+  assert(channel == 'events')
+  if message == 'stop' then
+    return false
+  end
+  table.insert(received, message)
+end)
+assert(#received == 2 and received[1] == 'hello' and received[2] == 'world')
+`); err != nil {
+		t.Errorf("failed to execute the script: %v", err)
+	}
+
+	// Stopped when the request is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	L2 := lua.NewState()
+	defer L2.Close()
+	if err := NewStdlib(conf).With("redis").Request(httptest.NewRecorder(), r).Install(L2); err != nil {
+		panic(err)
+	}
+	start := time.Now()
+	if err := L2.DoString(`
+local count = 0
+require('redis').new():subscribe({'events'}, function(channel, message)
+  count = count + 1
+end)
+assert(count == 3)
+`); err != nil {
+		t.Errorf("failed to execute the script: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("subscribe not stopped with the request, took %v", d)
+	}
+}
@@ -71,6 +71,7 @@ func newRequest(L *lua.LState, r *http.Request) (*lua.LUserData, error) {
 		"host":        requestHost,
 		"file":        requestFile,
 		"basic_auth":  requestBasicAuth,
+		"script_root": requestScriptRoot,
 	}))
 	ud := L.NewUserData()
 	ud.Value = req
@@ -222,3 +223,12 @@ func requestRemoteAddr(L *lua.LState) int {
 	L.Push(lua.LString(getIPAddress(request.request)))
 	return 1
 }
+
+func requestScriptRoot(L *lua.LState) int {
+	request := checkRequest(L)
+	if request == nil {
+		return 1
+	}
+	L.Push(lua.LString(scriptRoot(request.request)))
+	return 1
+}
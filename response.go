@@ -3,6 +3,7 @@ package gluapp
 import (
 	"bytes"
 	"net/http"
+	"time"
 
 	"github.com/yuin/gopher-lua"
 
@@ -17,16 +18,26 @@ type Response struct {
 	StatusCode int
 	redirect   string
 	req        *http.Request
+	reqBody    []byte // Cached request body, needed to proxy the request
+	proxy      *reverseProxy
+	route      string        // Pattern of the matched route (or page)
+	luaTime    time.Duration // Time spent executing Lua code
 }
 
 // WriteTo dumps the respons to the actual  response.
 func (resp *Response) WriteTo(w http.ResponseWriter) {
-	if resp.buf != nil {
-		resp.Body = resp.buf.Bytes()
-		resp.buf = nil
-	}
+	resp.flush()
 
 	if w != nil {
+		// Forward the request if `response:proxy` has been called
+		if resp.proxy != nil {
+			for k := range resp.Header {
+				w.Header().Del(k)
+			}
+			resp.proxy.serve(w, resp.req, resp.reqBody, resp.Header)
+			return
+		}
+
 		// Write the headers
 		for k, vs := range resp.Header {
 			// Reset existing values
@@ -50,6 +61,50 @@ func (resp *Response) WriteTo(w http.ResponseWriter) {
 	}
 }
 
+// flush moves the output written from Lua to `Body` (appended to it, as a middleware may have set it)
+func (resp *Response) flush() {
+	if resp.buf != nil {
+		resp.Body = append(resp.Body, resp.buf.Bytes()...)
+		// Keep the buffer usable, a middleware may run more Lua code writing to the response
+		resp.buf.Reset()
+	}
+}
+
+// responseWriter wraps a `Response` to implement the `http.ResponseWriter` interface
+type responseWriter struct {
+	resp *Response
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.resp.Header
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	return rw.resp.buf.Write(b)
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.resp.StatusCode = statusCode
+}
+
+// discardResponseWriter is an `http.ResponseWriter` discarding everything (used when no request is served)
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (rw *discardResponseWriter) Header() http.Header {
+	if rw.header == nil {
+		rw.header = http.Header{}
+	}
+	return rw.header
+}
+
+func (rw *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (rw *discardResponseWriter) WriteHeader(statusCode int) {}
+
 func newResponse(L *lua.LState, w http.ResponseWriter, r *http.Request) (*lua.LUserData, *Response) {
 	resp := &Response{
 		buf:        bytes.NewBuffer(nil),
@@ -76,6 +131,7 @@ func newResponse(L *lua.LState, w http.ResponseWriter, r *http.Request) (*lua.LU
 		"jsonify":      responseJsonify,
 		"error":        responseError,
 		"authenticate": responseAuthenticate,
+		"proxy":        responseProxy,
 	}
 	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), responseMethods))
 	ud := L.NewUserData()
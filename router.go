@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/yuin/gopher-lua"
@@ -15,6 +20,14 @@ import (
 
 const any = "any"
 
+// Path normalization policies, `strict` (the default) matches the path as is, `redirect` sends the client to the
+// canonical path (duplicate slashes, dot segments and trailing slash removed) and `lenient` silently matches it.
+const (
+	pathPolicyStrict   = "strict"
+	pathPolicyRedirect = "redirect"
+	pathPolicyLenient  = "lenient"
+)
+
 var (
 	errMethodNotAllowed = errors.New("method not allowed")
 	errNotFound         = errors.New("not found")
@@ -22,10 +35,24 @@ var (
 
 // route represents a registed route method/path
 type route struct {
-	path   string
-	method string
-	regexp *regexp.Regexp
-	data   interface{}
+	path    string
+	pattern string
+	method  string
+	regexp  *regexp.Regexp
+	data    interface{}
+	meta    map[string]interface{}
+}
+
+// Route represents a route registered with the router, as returned by `App.Routes`
+type Route struct {
+	Method string
+	Path   string
+
+	// Arbitrary metadata attached to the route at registration time
+	Meta map[string]interface{}
+
+	// ShadowedBy is set to the previously registered route that will always be matched first, if any
+	ShadowedBy *Route
 }
 
 // params represents a route named parameters
@@ -48,14 +75,41 @@ func (r *route) match(path string) (bool, params) {
 	return false, nil
 }
 
+// shadows returns true if the route will always be matched before the other one
+func (r *route) shadows(other *route) bool {
+	if r.method != other.method && r.method != any {
+		return false
+	}
+	if r.pattern == other.pattern {
+		return true
+	}
+	samplePath := other.path
+	if other.regexp != nil {
+		// Replace the named parameters with a dummy value
+		parts := strings.Split(other.pattern, "/")
+		for i, part := range parts {
+			if strings.HasPrefix(part, ":") {
+				parts[i] = "_"
+			}
+		}
+		samplePath = strings.Join(parts, "/")
+	}
+	match, _ := r.match(samplePath)
+	return match
+}
+
 // The Router implements a basic HTTP router that does not rely on `net/http` at all.
 //
 // It supports named parameters (`/hello/:name`), insertion order of routes does matter, the first matching route is
 // returned.
 type router struct {
 	method, path string
+	root         fs.FS
 	routes       []*route
+	mounts       []*staticMount
 	resp         *Response
+	pathPolicy   string
+	introspect   func(*router)
 }
 
 func (r *router) errorFunc(statusCode int, statusText string) {
@@ -65,13 +119,39 @@ func (r *router) errorFunc(statusCode int, statusText string) {
 	r.resp.buf = bytes.NewBufferString(statusText)
 }
 
-func setupRouter(resp *Response, method, path string) func(*lua.LState) int {
+// redirect sends the client to the given path (see `redirectTo`)
+func (r *router) redirect(p string) {
+	redirectTo(r.resp, r.method, p)
+}
+
+// redirectTo sends the client to the given path (keeping the query string and the script root), using a 301 for
+// GET/HEAD requests and a 308 for the others so the method and body are preserved.
+func redirectTo(resp *Response, method, p string) {
+	u := &url.URL{Path: scriptRoot(resp.req) + p}
+	if resp.req != nil {
+		u.RawQuery = resp.req.URL.RawQuery
+	}
+	statusCode := http.StatusMovedPermanently
+	if method != "GET" && method != "HEAD" {
+		statusCode = http.StatusPermanentRedirect
+	}
+	resp.StatusCode = statusCode
+	resp.redirect = u.String()
+}
+
+// setupRouter returns the router module loader, if `introspect` is set, `router:run()` will call it instead of
+// dispatching the request.
+//
+// `root` is the app filesystem, used to resolve relative static directories.
+func setupRouter(resp *Response, method, path string, root fs.FS, introspect func(*router)) func(*lua.LState) int {
 	return func(L *lua.LState) int {
 		// Setup the Lua meta table for the router user-defined type
 		mt := L.NewTypeMetatable("router")
 		routerMethods := map[string]lua.LGFunction{
-			"any": routerMethodFunc(any),
-			"run": routerRun,
+			"any":    routerMethodFunc(any),
+			"run":    routerRun,
+			"routes": routerRoutes,
+			"static": routerStatic,
 		}
 		for _, m := range methods {
 			routerMethods[strings.ToLower(m)] = routerMethodFunc(m)
@@ -82,10 +162,23 @@ func setupRouter(resp *Response, method, path string) func(*lua.LState) int {
 		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
 			"new": func(L *lua.LState) int {
 				router := &router{
-					routes: []*route{},
-					method: method,
-					path:   path,
-					resp:   resp,
+					routes:     []*route{},
+					method:     method,
+					path:       path,
+					root:       root,
+					resp:       resp,
+					pathPolicy: pathPolicyStrict,
+					introspect: introspect,
+				}
+				if opts := L.OptTable(1, nil); opts != nil {
+					if policy := opts.RawGetString("path_policy"); policy != lua.LNil {
+						switch p := policy.String(); p {
+						case pathPolicyStrict, pathPolicyRedirect, pathPolicyLenient:
+							router.pathPolicy = p
+						default:
+							L.ArgError(1, fmt.Sprintf("unknown path_policy %q", p))
+						}
+					}
 				}
 				ud := L.NewUserData()
 				ud.Value = router
@@ -116,24 +209,117 @@ func routerMethodFunc(method string) func(*lua.LState) int {
 		}
 		path := string(L.CheckString(2))
 		fn := L.CheckFunction(3)
+		var meta map[string]interface{}
+		if tbl := L.OptTable(4, nil); tbl != nil {
+			meta = luautil.TableToMap(L, tbl)
+		}
 		if method == "any" {
 			for _, m := range methods {
-				router.add(m, path, fn)
+				router.add(m, path, fn).meta = meta
 			}
 
 		} else {
-			router.add(method, path, fn)
+			router.add(method, path, fn).meta = meta
 		}
 		return 0
 	}
 }
 
+// routerStatic serves the files of a directory (relative to the app path, and inside of it) under the given prefix:
+// `router:static('/assets', 'assets', {listing = true})`.
+func routerStatic(L *lua.LState) int {
+	router := checkRouter(L)
+	if router == nil {
+		return 1
+	}
+	prefix := L.CheckString(2)
+	dir := L.CheckString(3)
+	// The directory must be inside the app
+	if filepath.IsAbs(dir) || path.IsAbs(filepath.ToSlash(dir)) || !fs.ValidPath(fsPath(dir)) {
+		L.ArgError(3, "the directory must be relative to the app and inside of it")
+	}
+	var listing bool
+	if opts := L.OptTable(4, nil); opts != nil {
+		listing = lua.LVAsBool(opts.RawGetString("listing"))
+	}
+	m, err := newStaticMount(router.root, "", prefix, dir, listing)
+	if err != nil {
+		L.RaiseError("failed to mount %s: %v", dir, err)
+	}
+	router.mounts = append(router.mounts, m)
+	// Keep the most specific prefixes first
+	sort.SliceStable(router.mounts, func(i, j int) bool {
+		return len(router.mounts[i].prefix) > len(router.mounts[j].prefix)
+	})
+	return 0
+}
+
+// routerRoutes returns the list of registered routes as `{method=, path=, meta=}` tables
+func routerRoutes(L *lua.LState) int {
+	router := checkRouter(L)
+	if router == nil {
+		return 1
+	}
+	out := L.CreateTable(len(router.routes), 0)
+	for _, rt := range router.routes {
+		tbl := L.CreateTable(0, 3)
+		tbl.RawSetH(lua.LString("method"), lua.LString(rt.method))
+		tbl.RawSetH(lua.LString("path"), lua.LString(rt.pattern))
+		if rt.meta != nil {
+			tbl.RawSetH(lua.LString("meta"), luautil.InterfaceToLValue(L, rt.meta))
+		} else {
+			tbl.RawSetH(lua.LString("meta"), L.NewTable())
+		}
+		out.Append(tbl)
+	}
+	L.Push(out)
+	return 1
+}
+
+// exportRoutes returns the registered routes (in insertion order) along with the shadowing info
+func (r *router) exportRoutes() []*Route {
+	out := make([]*Route, 0, len(r.routes))
+	for i, rt := range r.routes {
+		exported := &Route{
+			Method: rt.method,
+			Path:   rt.pattern,
+			Meta:   rt.meta,
+		}
+		for j, previous := range r.routes[:i] {
+			if previous.shadows(rt) {
+				exported.ShadowedBy = out[j]
+				break
+			}
+		}
+		out = append(out, exported)
+	}
+	return out
+}
+
 func routerRun(L *lua.LState) int {
 	router := checkRouter(L)
 	if router == nil {
 		return 1
 	}
-	fn, params, err := router.match(router.method, router.path)
+	if router.introspect != nil {
+		router.introspect(router)
+		return 0
+	}
+	// Static mounts take precedence over the routes
+	if router.resp.req != nil {
+		for _, m := range router.mounts {
+			if name, ok := m.match(router.path); ok {
+				if m.serve(&responseWriter{router.resp}, router.resp.req, name) {
+					return 0
+				}
+			}
+		}
+	}
+	rt, params, canonical, err := router.resolveRoute(router.method, router.path)
+	if err != errNotFound && canonical != router.path && router.pathPolicy == pathPolicyRedirect {
+		router.redirect(canonical)
+		return 0
+	}
 	switch err {
 	case nil:
 	case errNotFound:
@@ -147,12 +333,14 @@ func routerRun(L *lua.LState) int {
 	default:
 		panic(err)
 	}
+	// Used by the access log
+	router.resp.route = rt.pattern
 	p := map[string]interface{}{}
 	for k, v := range params {
 		p[k] = v
 	}
 	if err := L.CallByParam(lua.P{
-		Fn:      lua.LValue(fn.(*lua.LFunction)),
+		Fn:      lua.LValue(rt.data.(*lua.LFunction)),
 		NRet:    0,
 		Protect: true,
 	}, luautil.InterfaceToLValue(L, p)); err != nil {
@@ -162,12 +350,13 @@ func routerRun(L *lua.LState) int {
 }
 
 // Add adds the path to the router, order of insertions matters as the first matched route is returned.
-func (r *router) add(method, path string, data interface{}) {
+func (r *router) add(method, path string, data interface{}) *route {
 	// TODO(tsileo): make more verification on the path?
 	newRoute := &route{
-		data:   data,
-		path:   path,
-		method: method,
+		data:    data,
+		path:    path,
+		pattern: path,
+		method:  method,
 	}
 	if strings.Contains(path, ":") {
 		newRoute.path = ""
@@ -195,15 +384,66 @@ func (r *router) add(method, path string, data interface{}) {
 		}
 	}
 	r.routes = append(r.routes, newRoute)
+	return newRoute
+}
+
+// canonicalPath returns the cleaned version of the given path (no duplicate slashes, no dot segments and no trailing
+// slash).
+func canonicalPath(p string) string {
+	if p == "" || p[0] != '/' {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+// resolve matches the path according to the router path policy, and returns the path that actually matched.
+//
+// For the non-strict policies, the canonical path is tried first, then the canonical path with a trailing slash (for
+// routes registered with one).
+func (r *router) resolve(method, p string) (interface{}, params, string, error) {
+	rt, params, canonical, err := r.resolveRoute(method, p)
+	if rt == nil {
+		return nil, params, canonical, err
+	}
+	return rt.data, params, canonical, err
+}
+
+// resolveRoute is like resolve, but returns the matched route
+func (r *router) resolveRoute(method, p string) (*route, params, string, error) {
+	if r.pathPolicy == "" || r.pathPolicy == pathPolicyStrict {
+		rt, params, err := r.matchRoute(method, p)
+		return rt, params, p, err
+	}
+	clean := canonicalPath(p)
+	candidates := []string{clean}
+	if clean != "/" {
+		candidates = append(candidates, clean+"/")
+	}
+	for _, candidate := range candidates {
+		rt, params, err := r.matchRoute(method, candidate)
+		if err != errNotFound {
+			return rt, params, candidate, err
+		}
+	}
+	return nil, nil, p, errNotFound
 }
 
 // Match returns the given route data alog with the params if any matches
 func (r *router) match(method, path string) (interface{}, params, error) {
+	rt, params, err := r.matchRoute(method, path)
+	if rt == nil {
+		return nil, params, err
+	}
+	return rt.data, params, err
+}
+
+// matchRoute is like match, but returns the matched route
+func (r *router) matchRoute(method, path string) (*route, params, error) {
 	var methodNotAllowed bool
 	for _, rt := range r.routes {
 		match, params := rt.match(path)
 		if match && (rt.method == any || rt.method == method) {
-			return rt.data, params, nil
+			return rt, params, nil
 		}
 		if match && rt.method != method {
 			methodNotAllowed = true
@@ -50,3 +50,60 @@ func TestRouter(t *testing.T) {
 		check(testData.method, testData.path2, testData.expectedData, testData.expectedParams, testData.expectedErr)
 	}
 }
+
+var testPathPolicy = []struct {
+	policy, path, expectedPath, expectedData string
+	expectedErr                              error
+}{
+	{pathPolicyStrict, "/bar", "/bar", "bar", nil},
+	{pathPolicyStrict, "/bar/", "/bar/", "", errNotFound},
+	{pathPolicyStrict, "//bar", "//bar", "", errNotFound},
+	{pathPolicyRedirect, "/bar/", "/bar", "bar", nil},
+	{pathPolicyRedirect, "//bar", "/bar", "bar", nil},
+	{pathPolicyRedirect, "/foo/../bar", "/bar", "bar", nil},
+	{pathPolicyRedirect, "/blog", "/blog/", "blog", nil},
+	{pathPolicyRedirect, "/blog/", "/blog/", "blog", nil},
+	{pathPolicyRedirect, "/nope/", "/nope/", "", errNotFound},
+	{pathPolicyLenient, "/./bar//", "/bar", "bar", nil},
+	{pathPolicyLenient, "/hello//thomas", "/hello/thomas", "hellop", nil},
+}
+
+func TestRouterPathPolicy(t *testing.T) {
+	for _, tdata := range testPathPolicy {
+		r := &router{routes: []*route{}, pathPolicy: tdata.policy}
+		r.add("GET", "/bar", "bar")
+		r.add("GET", "/blog/", "blog")
+		r.add("GET", "/hello/:name", "hellop")
+		data, _, p, err := r.resolve("GET", tdata.path)
+		if err != tdata.expectedErr {
+			t.Errorf("%s %s: got %+v expected %v", tdata.policy, tdata.path, err, tdata.expectedErr)
+		}
+		if p != tdata.expectedPath {
+			t.Errorf("%s %s: got path %q expected %q", tdata.policy, tdata.path, p, tdata.expectedPath)
+		}
+		if data != nil && data.(string) != tdata.expectedData {
+			t.Errorf("%s %s: got %+v expected %q", tdata.policy, tdata.path, data, tdata.expectedData)
+		}
+	}
+}
+
+func TestRouterShadowedRoutes(t *testing.T) {
+	r := &router{routes: []*route{}}
+	r.add("GET", "/hello/:name", "hellop")
+	r.add("GET", "/hello/ok", "hellok")
+	r.add("POST", "/hello/ok", "hellokpost")
+	r.add("GET", "/another/:foo", "another")
+	r.add("GET", "/another/:bar", "another2")
+	r.add("GET", "/foo", "foo")
+
+	expected := []string{"", "/hello/:name", "", "", "/another/:foo", ""}
+	for i, rt := range r.exportRoutes() {
+		var shadowedBy string
+		if rt.ShadowedBy != nil {
+			shadowedBy = rt.ShadowedBy.Path
+		}
+		if shadowedBy != expected[i] {
+			t.Errorf("%s %s: got shadowed by %q, expected %q", rt.Method, rt.Path, shadowedBy, expected[i])
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package gluapp
+
+import (
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Capabilities controls what the Lua code is allowed to do (for hosting untrusted apps), a function/module needing a
+// disabled capability raises an error when used.
+//
+// A nil `Config.Capabilities` grants everything.
+type Capabilities struct {
+	// Execute processes (the `cmd` module, `os.execute`, `io.popen`)
+	Exec bool
+
+	// Read files (`util.read_file`/`read_yaml`, the `fs` and `sql` modules, the `io` lib, `dofile`/`loadfile`,
+	// `router:static`), the app Lua modules and templates can always be loaded (`package.path` is reset before each
+	// lookup if disabled)
+	FSRead bool
+
+	// Write/delete files (`util.delete_file`, the `fs`, `kv` and `sql` modules, the `io` lib, `os.remove`/`os.rename`)
+	FSWrite bool
+
+	// Perform outgoing requests (the `http` and `redis` modules, `app.response:proxy`)
+	Network bool
+
+	// Fetch Lua code from GitHub with `require2`
+	RemoteRequire bool
+
+	// Use the `os` lib (`os.time`/`os.clock`/`os.date`/`os.difftime` are always available)
+	OSLib bool
+}
+
+// Capability names, used in error messages
+const (
+	capExec          = "exec"
+	capFSRead        = "fs_read"
+	capFSWrite       = "fs_write"
+	capNetwork       = "network"
+	capRemoteRequire = "remote_require"
+	capOSLib         = "os_lib"
+)
+
+// granted returns true if the capability is granted
+func (c *Capabilities) granted(capability string) bool {
+	if c == nil {
+		return true
+	}
+	switch capability {
+	case capExec:
+		return c.Exec
+	case capFSRead:
+		return c.FSRead
+	case capFSWrite:
+		return c.FSWrite
+	case capNetwork:
+		return c.Network
+	case capRemoteRequire:
+		return c.RemoteRequire
+	case capOSLib:
+		return c.OSLib
+	}
+	return false
+}
+
+// check raises a Lua error if one of the capabilities is not granted
+func (c *Capabilities) check(L *lua.LState, what string, capabilities ...string) {
+	for _, capability := range capabilities {
+		if !c.granted(capability) {
+			L.RaiseError("%s is not allowed (the %q capability is disabled)", what, capability)
+		}
+	}
+}
+
+// need returns a function that always returns the given capabilities (for functions whose needs don't depend on
+// their arguments)
+func need(capabilities ...string) func(*lua.LState) []string {
+	return func(*lua.LState) []string {
+		return capabilities
+	}
+}
+
+// Modules needing capabilities
+var sandboxedModules = map[string][]string{
+	"cmd":   {capExec},
+	"http":  {capNetwork},
+	"kv":    {capFSWrite}, // The database file is created when opened
+	"redis": {capNetwork},
+	"sql":   {capFSRead, capFSWrite}, // Files can be read/written with some drivers (e.g. SQLite `ATTACH DATABASE`)
+}
+
+// Functions needing capabilities, by module
+var sandboxedModuleFuncs = map[string]map[string]func(*lua.LState) []string{
+	"util": {
+		"read_file":   need(capFSRead),
+		"read_yaml":   need(capFSRead),
+		"delete_file": need(capFSWrite),
+	},
+	"fs": {
+		"read":   need(capFSRead),
+		"list":   need(capFSRead),
+		"stat":   need(capFSRead),
+		"write":  need(capFSWrite),
+		"append": need(capFSWrite),
+		"mkdir":  need(capFSWrite),
+		"remove": need(capFSWrite),
+		"rename": need(capFSWrite),
+		"lock":   need(capFSWrite),
+	},
+}
+
+// Methods needing capabilities, by module (the user-defined type has the same name as the module)
+var sandboxedModuleMethods = map[string]map[string]func(*lua.LState) []string{
+	"router": {
+		"static": need(capFSRead),
+	},
+}
+
+// Functions of the base libs needing capabilities, by lib ("" for the globals)
+var sandboxedLibFuncs = map[string]map[string]func(*lua.LState) []string{
+	"": {
+		"dofile":   need(capFSRead),
+		"loadfile": need(capFSRead),
+	},
+	"os": {
+		"execute":   need(capOSLib, capExec),
+		"exit":      need(capOSLib),
+		"getenv":    need(capOSLib),
+		"setenv":    need(capOSLib),
+		"setlocale": need(capOSLib),
+		"remove":    need(capOSLib, capFSWrite),
+		"rename":    need(capOSLib, capFSWrite),
+		"tmpname":   need(capOSLib, capFSWrite),
+	},
+	"io": {
+		"open": func(L *lua.LState) []string {
+			// Only reading is needed for the "r" mode
+			mode := L.OptString(2, "r")
+			switch {
+			case strings.HasPrefix(mode, "r") && !strings.Contains(mode, "+"):
+				return []string{capFSRead}
+			case strings.HasPrefix(mode, "r"):
+				return []string{capFSRead, capFSWrite}
+			}
+			return []string{capFSWrite}
+		},
+		"lines":   need(capFSRead),
+		"input":   need(capFSRead),
+		"output":  need(capFSWrite),
+		"tmpfile": need(capFSWrite),
+		"popen":   need(capExec),
+	},
+}
+
+// guardFunc wraps the function to check the capabilities before calling it
+func guardFunc(L *lua.LState, caps *Capabilities, what string, fn lua.LValue, needs func(*lua.LState) []string) *lua.LFunction {
+	return L.NewFunction(func(L *lua.LState) int {
+		caps.check(L, what, needs(L)...)
+		top := L.GetTop()
+		L.Push(fn)
+		for i := 1; i <= top; i++ {
+			L.Push(L.Get(i))
+		}
+		L.Call(top, lua.MultRet)
+		return L.GetTop() - top
+	})
+}
+
+// guardTable wraps the functions of the table that need a capability that is not granted
+func guardTable(L *lua.LState, caps *Capabilities, prefix string, tbl *lua.LTable, funcs map[string]func(*lua.LState) []string) {
+	for name, needs := range funcs {
+		fn := tbl.RawGetString(name)
+		if fn == lua.LNil {
+			continue
+		}
+		tbl.RawSetString(name, guardFunc(L, caps, "`"+prefix+name+"`", fn, needs))
+	}
+}
+
+// wrapLoader replaces the preloaded module loader to call `fn` with the loaded module
+func wrapLoader(L *lua.LState, preload lua.LValue, name string, fn func(mod *lua.LTable)) {
+	loader, ok := L.GetField(preload, name).(*lua.LFunction)
+	if !ok {
+		return
+	}
+	L.SetField(preload, name, L.NewFunction(func(L *lua.LState) int {
+		L.Push(loader)
+		L.Push(L.Get(1))
+		L.Call(1, 1)
+		if mod, ok := L.Get(-1).(*lua.LTable); ok {
+			fn(mod)
+		}
+		return 1
+	}))
+}
+
+// setupSandbox restricts the Lua state according to the capabilities, it must be called once the modules are
+// preloaded
+func setupSandbox(L *lua.LState, caps *Capabilities) {
+	if caps == nil {
+		return
+	}
+	preload := L.GetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "preload")
+
+	// Replace the disabled modules with loaders raising an error
+	for name, capabilities := range sandboxedModules {
+		if L.GetField(preload, name) == lua.LNil {
+			continue
+		}
+		granted := true
+		for _, capability := range capabilities {
+			granted = granted && caps.granted(capability)
+		}
+		if granted {
+			continue
+		}
+		what, capabilities := "the `"+name+"` module", capabilities
+		L.SetField(preload, name, L.NewFunction(func(L *lua.LState) int {
+			caps.check(L, what, capabilities...)
+			return 0
+		}))
+	}
+
+	// Guard the functions of the modules once loaded
+	for name, funcs := range sandboxedModuleFuncs {
+		prefix, funcs := name+".", funcs
+		wrapLoader(L, preload, name, func(mod *lua.LTable) {
+			guardTable(L, caps, prefix, mod, funcs)
+		})
+	}
+
+	// Guard the methods of the user-defined types once the module defining them is loaded
+	for name, funcs := range sandboxedModuleMethods {
+		name, funcs := name, funcs
+		wrapLoader(L, preload, name, func(*lua.LTable) {
+			mt, ok := L.GetTypeMetatable(name).(*lua.LTable)
+			if !ok {
+				return
+			}
+			if methods, ok := L.GetField(mt, "__index").(*lua.LTable); ok {
+				guardTable(L, caps, name+":", methods, funcs)
+			}
+		})
+	}
+
+	// Guard the base libs
+	for lib, funcs := range sandboxedLibFuncs {
+		if lib == "" {
+			guardTable(L, caps, "", L.Get(lua.GlobalsIndex).(*lua.LTable), funcs)
+			continue
+		}
+		if tbl, ok := L.GetGlobal(lib).(*lua.LTable); ok {
+			guardTable(L, caps, lib+".", tbl, funcs)
+		}
+	}
+
+	// The Lua modules are looked up using `package.path`, pin it so `require` can't be used to read files
+	if !caps.granted(capFSRead) {
+		pinPackagePaths(L)
+	}
+
+	// `require2` is a global function
+	if fn := L.GetGlobal("require2"); fn != lua.LNil {
+		L.SetGlobal("require2", guardFunc(L, caps, "`require2`", fn, need(capRemoteRequire)))
+	}
+
+	// Proxying a request needs the network
+	if mt, ok := L.GetTypeMetatable("response").(*lua.LTable); ok {
+		if methods, ok := L.GetField(mt, "__index").(*lua.LTable); ok {
+			guardTable(L, caps, "response:", methods, map[string]func(*lua.LState) []string{
+				"proxy": need(capNetwork),
+			})
+		}
+	}
+}
+
+// pinPackagePaths wraps the `require` loaders to restore `package.path`/`package.cpath` to their current values
+// before looking up a module
+func pinPackagePaths(L *lua.LState) {
+	pkg := L.GetField(L.Get(lua.EnvironIndex), "package")
+	pinned := map[string]lua.LValue{"path": L.GetField(pkg, "path"), "cpath": L.GetField(pkg, "cpath")}
+	// `require` uses the loaders from the registry (`package.loaders` can be replaced from Lua)
+	loaders, ok := L.GetField(L.Get(lua.RegistryIndex), "_LOADERS").(*lua.LTable)
+	if !ok {
+		return
+	}
+	for i := 1; i <= loaders.Len(); i++ {
+		loader := loaders.RawGetInt(i)
+		loaders.RawSetInt(i, L.NewFunction(func(L *lua.LState) int {
+			pkg := L.GetField(L.Get(lua.EnvironIndex), "package")
+			if pkg, ok := pkg.(*lua.LTable); ok {
+				for name, value := range pinned {
+					pkg.RawSetString(name, value)
+				}
+			}
+			top := L.GetTop()
+			L.Push(loader)
+			for i := 1; i <= top; i++ {
+				L.Push(L.Get(i))
+			}
+			L.Call(top, lua.MultRet)
+			return L.GetTop() - top
+		}))
+	}
+}
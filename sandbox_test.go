@@ -0,0 +1,67 @@
+package gluapp
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestSandbox(t *testing.T) {
+	readOnly := &Capabilities{FSRead: true}
+
+	testData := []struct {
+		caps        *Capabilities
+		code        string
+		expectedErr string
+	}{
+		// Everything is allowed by default
+		{nil, `assert(require('cmd').run ~= nil)`, ""},
+		{nil, `assert(os.getenv('GLUAPP_UNDEFINED') == nil)`, ""},
+		{readOnly, `require('cmd')`, "the `cmd` module is not allowed (the \"exec\" capability is disabled)"},
+		{readOnly, `require('http')`, "\"network\" capability"},
+		{readOnly, `assert(require('util').read_file('tests_data/app/app.lua') ~= '')`, ""},
+		{readOnly, `require('util').delete_file('nope')`, "`util.delete_file` is not allowed"},
+		{nil, `require('util').read_file('../../../etc/passwd')`, "path outside of the root directory"},
+		{nil, `require('util').read_yaml('/etc/passwd')`, "path outside of the root directory"},
+		{nil, `require('util').delete_file('../nope')`, "path outside of the root directory"},
+		{readOnly, `require('kv')`, "the `kv` module is not allowed (the \"fs_write\" capability is disabled)"},
+		{readOnly, `io.open('tests_data/app/app.lua'):close()`, ""},
+		{readOnly, `io.open('tests_data/nope', 'w')`, "`io.open` is not allowed (the \"fs_write\" capability is disabled)"},
+		{readOnly, `io.popen('ls')`, "\"exec\" capability"},
+		{readOnly, `os.execute('ls')`, "\"os_lib\" capability"},
+		{&Capabilities{OSLib: true}, `os.execute('ls')`, "\"exec\" capability"},
+		{&Capabilities{}, `assert(os.time() > 0 and os.date('%Y') ~= '')`, ""},
+		{&Capabilities{}, `dofile('tests_data/app/app.lua')`, "\"fs_read\" capability"},
+		{&Capabilities{}, `require2('github.com/tsileo/gluapp/lol')`, "`require2` is not allowed"},
+		{&Capabilities{}, `app.response:proxy('http://localhost')`, "`response:proxy` is not allowed"},
+		{&Capabilities{}, `require('json')`, ""},
+		{readOnly, `require('sql')`, "the `sql` module is not allowed (the \"fs_write\" capability is disabled)"},
+		{&Capabilities{FSWrite: true}, `require('sql')`, "the `sql` module is not allowed (the \"fs_read\" capability is disabled)"},
+		{&Capabilities{}, `require('router').new():static('/x', 'assets')`, "`router:static` is not allowed"},
+		{readOnly, `require('router').new():static('/x', 'assets')`, ""},
+		{nil, `require('router').new():static('/x', '/', {listing = true})`, "the directory must be relative to the app"},
+		{nil, `require('router').new():static('/x', '../..')`, "the directory must be relative to the app"},
+		// The templates and the Lua modules can't be used to read other files
+		{&Capabilities{}, `assert(require('template').render('../../../etc/passwd', {}) == 'path outside of the root directory')`, ""},
+		{&Capabilities{}, `package.path = '/etc/?'; require('passwd')`, "module passwd not found"},
+		{&Capabilities{}, `package = {path = '/etc/?', preload = {}}; require('passwd')`, "module passwd not found"},
+	}
+
+	for _, tdata := range testData {
+		L := lua.NewState()
+		err := NewStdlib(&Config{Capabilities: tdata.caps}).All().Request(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)).Install(L)
+		if err != nil {
+			panic(err)
+		}
+		err = L.DoString(tdata.code)
+		L.Close()
+		switch {
+		case tdata.expectedErr == "" && err != nil:
+			t.Errorf("unexpected error for %q: %v", tdata.code, err)
+		case tdata.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tdata.expectedErr)):
+			t.Errorf("bad error for %q, got %v, expected %q", tdata.code, err, tdata.expectedErr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package gluapp
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// RunScript runs a Lua file outside of an HTTP request (cron jobs, maintenance scripts...) and returns its exit code.
+//
+// The modules are the same as for the apps, except the request specific ones (`app` and `router`). The file is read
+// from `conf.FS`, `conf.Path` or the current directory (absolute paths work without `conf.FS`). The arguments are in
+// the `arg` table (`arg[0]` is the script name). The exit code is the number returned by the script (0 by default),
+// or 1 if the script raised an error.
+//
+// `conf.SetupState` is called with a nil `http.ResponseWriter` and `*http.Request`.
+func RunScript(conf *Config, file string, args []string) (int, error) {
+	// Initialize a Lua state
+	L := lua.NewState()
+	defer L.Close()
+
+	// Preload all the modules and setup global variables
+	if err := SetupGlue(L, conf, nil, nil); err != nil {
+		return 1, err
+	}
+
+	// Setup `arg`
+	arg := L.CreateTable(len(args), 1)
+	arg.RawSetInt(0, lua.LString(file))
+	for i, a := range args {
+		arg.RawSetInt(i+1, lua.LString(a))
+	}
+	L.SetGlobal("arg", arg)
+
+	// Execute the script
+	fsys, name := appFS(conf, conf.FS), fsPath(file)
+	if conf.FS == nil && filepath.IsAbs(file) {
+		fsys, name = os.DirFS(filepath.Dir(file)), filepath.Base(file)
+	}
+	top := L.GetTop()
+	if err := doFile(L, fsys, name, scriptChunkName(conf, file)); err != nil {
+		return 1, err
+	}
+
+	if conf.AfterScriptExecHook != nil {
+		if err := conf.AfterScriptExecHook(L); err != nil {
+			return 1, err
+		}
+	}
+
+	// Use the first returned value as the exit code
+	if L.GetTop() > top {
+		if code, ok := L.Get(top + 1).(lua.LNumber); ok {
+			return int(code), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// scriptChunkName returns the name of the script for error messages
+func scriptChunkName(conf *Config, file string) string {
+	if conf.FS != nil || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(conf.Path, file)
+}
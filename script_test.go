@@ -0,0 +1,49 @@
+package gluapp
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestRunScript(t *testing.T) {
+	var output lua.LValue
+	conf := &Config{
+		Path: "tests_data/scripts",
+		AfterScriptExecHook: func(L *lua.LState) error {
+			output = L.GetGlobal("output")
+			return nil
+		},
+	}
+
+	testData := []struct {
+		conf           *Config
+		file           string
+		args           []string
+		expectedCode   int
+		expectedOutput lua.LValue
+		expectedErr    bool
+	}{
+		{conf, "sum.lua", []string{"1", "2", "3"}, 0, lua.LNumber(6), false},
+		{conf, "sum.lua", []string{"1"}, 2, lua.LNil, false},
+		{conf, "nope.lua", nil, 1, lua.LNil, true},
+		{&Config{FS: fstest.MapFS{
+			"fail.lua": &fstest.MapFile{Data: []byte(`error('failed')`)},
+		}}, "fail.lua", nil, 1, lua.LNil, true},
+	}
+
+	for _, tdata := range testData {
+		output = lua.LNil
+		code, err := RunScript(tdata.conf, tdata.file, tdata.args)
+		if (err != nil) != tdata.expectedErr {
+			t.Errorf("unexpected error for %s %v: %v", tdata.file, tdata.args, err)
+		}
+		if code != tdata.expectedCode {
+			t.Errorf("bad exit code for %s %v, got %d, expected %d", tdata.file, tdata.args, code, tdata.expectedCode)
+		}
+		if output != tdata.expectedOutput {
+			t.Errorf("bad output for %s %v, got %v, expected %v", tdata.file, tdata.args, output, tdata.expectedOutput)
+		}
+	}
+}
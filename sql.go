@@ -0,0 +1,332 @@
+package gluapp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// The `sql` module gives access to the databases registered in `Config.Databases`:
+//
+//	local db = require('sql').open('main')
+//	local users = db:query('SELECT id, name FROM users WHERE id > ?', 10)
+//	local user = db:query_row('SELECT id, name FROM users WHERE id = ?', 1)
+//	local res = db:exec('DELETE FROM users WHERE id = ?', 1)
+//	db:transaction(function(tx)
+//	  -- rolled back if an error is raised
+//	  tx:exec('INSERT INTO users (name) VALUES (?)', 'thomas')
+//	end)
+//
+// Rows are returned as tables keyed by column name, NULL values are nil, `[]byte` values are returned as strings and
+// times as RFC 3339 strings.
+
+// Database represents a database available from the `sql` module, the connection pool is shared by all the requests.
+//
+// The driver must be registered by the embedder (e.g. `import _ "modernc.org/sqlite"`).
+type Database struct {
+	// Driver name and data source name, as passed to `sql.Open`
+	Driver string
+	DSN    string
+
+	// Connection pool settings (the `database/sql` defaults are used if 0)
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// Already opened database to use instead of `Driver`/`DSN`
+	DB *sql.DB
+
+	mu   sync.Mutex
+	db   *sql.DB
+	refs int // Number of apps using the database
+}
+
+// open returns the database, opening it on first use
+func (d *Database) open() (*sql.DB, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.db != nil {
+		return d.db, nil
+	}
+	if d.DB != nil {
+		d.db = d.DB
+		return d.db, nil
+	}
+	db, err := sql.Open(d.Driver, d.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if d.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(d.MaxOpenConns)
+	}
+	if d.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(d.MaxIdleConns)
+	}
+	if d.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(d.ConnMaxLifetime)
+	}
+	d.db = db
+	return db, nil
+}
+
+// Close closes the connection pool (if it was opened from `Driver`/`DSN`).
+func (d *Database) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.close()
+}
+
+func (d *Database) close() error {
+	if d.db == nil || d.db == d.DB {
+		return nil
+	}
+	err := d.db.Close()
+	d.db = nil
+	return err
+}
+
+// acquire registers an app using the database
+func (d *Database) acquire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refs++
+}
+
+// release closes the connection pool once no app is using it (the config may be shared, e.g. by the apps of a `Mux`)
+func (d *Database) release() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.refs--; d.refs > 0 {
+		return nil
+	}
+	return d.close()
+}
+
+// sortedDatabases returns the databases of the config sorted by name
+func sortedDatabases(conf *Config) []*Database {
+	var names []string
+	for name := range conf.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []*Database
+	for _, name := range names {
+		out = append(out, conf.Databases[name])
+	}
+	return out
+}
+
+// acquireDatabases registers an app using the databases of the config
+func acquireDatabases(conf *Config) {
+	for _, d := range sortedDatabases(conf) {
+		d.acquire()
+	}
+}
+
+// releaseDatabases releases the databases of the config used by an app
+func releaseDatabases(conf *Config) error {
+	for _, d := range sortedDatabases(conf) {
+		if err := d.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryer is implemented by `*sql.DB` and `*sql.Tx`
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlConn is the `sql_conn` user-defined type, a database or a transaction
+type sqlConn struct {
+	ctx context.Context
+	q   queryer
+	db  *sql.DB // Nil for transactions
+}
+
+func checkSQLConn(L *lua.LState) *sqlConn {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value.(*sqlConn); ok {
+		return v
+	}
+	L.ArgError(1, "sql connection expected")
+	return nil
+}
+
+func newSQLConn(L *lua.LState, conn *sqlConn) lua.LValue {
+	ud := L.NewUserData()
+	ud.Value = conn
+	L.SetMetatable(ud, L.GetTypeMetatable("sql_conn"))
+	return ud
+}
+
+// sqlArgs converts the query arguments (starting at index `n`) to Go values
+func sqlArgs(L *lua.LState, n int) []interface{} {
+	var args []interface{}
+	for i := n; i <= L.GetTop(); i++ {
+		switch v := L.Get(i).(type) {
+		case *lua.LNilType:
+			args = append(args, nil)
+		case lua.LBool:
+			args = append(args, bool(v))
+		case lua.LNumber:
+			if float64(v) == float64(int64(v)) {
+				args = append(args, int64(v))
+			} else {
+				args = append(args, float64(v))
+			}
+		case lua.LString:
+			args = append(args, string(v))
+		default:
+			L.ArgError(i, fmt.Sprintf("unsupported query argument type %s", v.Type()))
+		}
+	}
+	return args
+}
+
+// sqlValue converts a value returned by the driver to a Lua value
+func sqlValue(v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case []byte:
+		return lua.LString(v)
+	case string:
+		return lua.LString(v)
+	case int64:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case time.Time:
+		return lua.LString(v.Format(time.RFC3339Nano))
+	default:
+		return lua.LString(fmt.Sprintf("%v", v))
+	}
+}
+
+// query executes the query and returns the rows as Lua tables (at most `limit` rows if not 0)
+func (c *sqlConn) query(L *lua.LState, limit int) *lua.LTable {
+	rows, err := c.q.QueryContext(c.ctx, L.CheckString(2), sqlArgs(L, 3)...)
+	if err != nil {
+		L.RaiseError("query failed: %v", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		L.RaiseError("query failed: %v", err)
+	}
+	res := L.NewTable()
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			L.RaiseError("query failed: %v", err)
+		}
+		row := L.CreateTable(0, len(cols))
+		for i, col := range cols {
+			row.RawSetString(col, sqlValue(vals[i]))
+		}
+		res.Append(row)
+		if limit > 0 && res.Len() >= limit {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		L.RaiseError("query failed: %v", err)
+	}
+	return res
+}
+
+func setupSQL(ctx context.Context, databases map[string]*Database) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		mt := L.NewTypeMetatable("sql_conn")
+		L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"query": func(L *lua.LState) int {
+				c := checkSQLConn(L)
+				L.Push(c.query(L, 0))
+				return 1
+			},
+			// Returns the first row, or nil
+			"query_row": func(L *lua.LState) int {
+				c := checkSQLConn(L)
+				L.Push(c.query(L, 1).RawGetInt(1))
+				return 1
+			},
+			"exec": func(L *lua.LState) int {
+				c := checkSQLConn(L)
+				res, err := c.q.ExecContext(c.ctx, L.CheckString(2), sqlArgs(L, 3)...)
+				if err != nil {
+					L.RaiseError("exec failed: %v", err)
+				}
+				tbl := L.CreateTable(0, 2)
+				// Not all the drivers support them
+				if n, err := res.RowsAffected(); err == nil {
+					tbl.RawSetString("rows_affected", lua.LNumber(n))
+				}
+				if id, err := res.LastInsertId(); err == nil {
+					tbl.RawSetString("last_insert_id", lua.LNumber(id))
+				}
+				L.Push(tbl)
+				return 1
+			},
+			// Executes the callback in a transaction (committed if no errors are raised)
+			"transaction": func(L *lua.LState) int {
+				c := checkSQLConn(L)
+				fn := L.CheckFunction(2)
+				if c.db == nil {
+					L.RaiseError("nested transactions are not supported")
+				}
+				tx, err := c.db.BeginTx(c.ctx, nil)
+				if err != nil {
+					L.RaiseError("failed to begin the transaction: %v", err)
+				}
+				top := L.GetTop()
+				L.Push(fn)
+				L.Push(newSQLConn(L, &sqlConn{ctx: c.ctx, q: tx}))
+				if err := L.PCall(1, lua.MultRet, nil); err != nil {
+					if rerr := tx.Rollback(); rerr != nil {
+						L.RaiseError("failed to rollback the transaction: %v (%v)", rerr, err)
+					}
+					if lerr, ok := err.(*lua.ApiError); ok {
+						L.Error(lerr.Object, 0)
+					}
+					L.RaiseError("%v", err)
+				}
+				if err := tx.Commit(); err != nil {
+					L.RaiseError("failed to commit the transaction: %v", err)
+				}
+				return L.GetTop() - top
+			},
+		}))
+
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"open": func(L *lua.LState) int {
+				name := L.CheckString(1)
+				d, ok := databases[name]
+				if !ok {
+					L.ArgError(1, fmt.Sprintf("unknown database %q", name))
+				}
+				db, err := d.open()
+				if err != nil {
+					L.RaiseError("failed to open the database: %v", err)
+				}
+				L.Push(newSQLConn(L, &sqlConn{ctx: ctx, q: db, db: db}))
+				return 1
+			},
+		})
+		L.Push(mod)
+		return 1
+	}
+}
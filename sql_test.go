@@ -0,0 +1,112 @@
+package gluapp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yuin/gopher-lua"
+)
+
+func TestSQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	conf := &Config{Databases: map[string]*Database{"main": {DB: db}}}
+
+	created := time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, name, avatar, created FROM users WHERE id > \\?").
+		WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "avatar", "created"}).
+			AddRow(int64(2), "thomas", []byte("png"), created).
+			AddRow(int64(3), nil, nil, created))
+	mock.ExpectQuery("SELECT name FROM users WHERE id = \\?").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectExec("UPDATE users SET score = \\? WHERE name = \\? AND admin = \\?").
+		WithArgs(1.5, "thomas", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Committed transaction
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WithArgs("alice").WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+	// Rolled back transaction
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WithArgs("bob").WillReturnError(errors.New("constraint failed"))
+	mock.ExpectRollback()
+
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(conf).With("sql").Install(L); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(`
+local db = require('sql').open('main')
+local users = db:query('SELECT id, name, avatar, created FROM users WHERE id > ?', 1)
+assert(#users == 2)
+assert(users[1].id == 2 and users[1].name == 'thomas' and users[1].avatar == 'png')
+assert(users[1].created == '2020-03-14T15:09:26Z', users[1].created)
+assert(users[2].name == nil and users[2].avatar == nil)
+assert(db:query_row('SELECT name FROM users WHERE id = ?', 42) == nil)
+local res = db:exec('UPDATE users SET score = ? WHERE name = ? AND admin = ?', 1.5, 'thomas', true)
+assert(res.rows_affected == 1)
+local id = db:transaction(function(tx)
+  return tx:exec('INSERT INTO users (name) VALUES (?)', 'alice').last_insert_id
+end)
+assert(id == 4)
+local ok, err = pcall(function()
+  db:transaction(function(tx)
+    tx:exec('INSERT INTO users (name) VALUES (?)', 'bob')
+  end)
+end)
+assert(not ok and string.find(tostring(err), 'constraint failed'))
+`); err != nil {
+		t.Errorf("failed to execute the script: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+
+	// Unknown databases
+	if err := L.DoString(`require('sql').open('nope')`); err == nil || !strings.Contains(err.Error(), "unknown database") {
+		t.Errorf("bad error for unknown database: %v", err)
+	}
+}
+
+func TestSQLDriver(t *testing.T) {
+	// The databases can also be opened from a driver name and a DSN
+	db, mock, err := sqlmock.NewWithDSN("gluapp_sql_driver")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	conf := &Config{Databases: map[string]*Database{
+		"main": {Driver: "sqlmock", DSN: "gluapp_sql_driver", MaxOpenConns: 2},
+	}}
+	mock.ExpectQuery("SELECT name FROM users WHERE id = \\?").
+		WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("thomas"))
+	mock.ExpectClose()
+
+	L := lua.NewState()
+	defer L.Close()
+	if err := NewStdlib(conf).With("sql").Install(L); err != nil {
+		panic(err)
+	}
+	if err := L.DoString(`
+local db = require('sql').open('main')
+assert(db:query_row('SELECT name FROM users WHERE id = ?', 1).name == 'thomas')
+`); err != nil {
+		t.Errorf("failed to execute the script: %v", err)
+	}
+	if err := conf.Databases["main"].Close(); err != nil {
+		panic(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
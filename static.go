@@ -0,0 +1,147 @@
+package gluapp
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// staticMount serves the files of a directory under an URL prefix
+type staticMount struct {
+	prefix  string
+	fs      http.FileSystem
+	listing bool
+}
+
+// newStaticMount returns a new mount, relative directories are looked up in `base` if set (the app directory), in the
+// app filesystem otherwise (and they must stay inside it)
+func newStaticMount(root fs.FS, base, prefix, dir string, listing bool) (*staticMount, error) {
+	var hfs http.FileSystem
+	switch {
+	case filepath.IsAbs(dir):
+		hfs = http.Dir(dir)
+	case base != "":
+		hfs = http.Dir(filepath.Join(base, dir))
+	default:
+		p := fsPath(dir)
+		if !fs.ValidPath(p) {
+			return nil, fmt.Errorf("static directory %q is outside of the app filesystem", dir)
+		}
+		sub, err := fs.Sub(root, p)
+		if err != nil {
+			return nil, err
+		}
+		hfs = http.FS(sub)
+	}
+	// The prefix is stored without trailing slash ("/" is stored as "")
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	return &staticMount{
+		prefix:  prefix,
+		fs:      hfs,
+		listing: listing,
+	}, nil
+}
+
+// newStaticMounts returns the mounts sorted by prefix length so the most specific one is tried first
+func newStaticMounts(root fs.FS, base string, mounts map[string]string, listing bool) ([]*staticMount, error) {
+	var out []*staticMount
+	for prefix, dir := range mounts {
+		m, err := newStaticMount(root, base, prefix, dir, listing)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return len(out[i].prefix) > len(out[j].prefix)
+	})
+	return out, nil
+}
+
+// match returns the name of the file (relative to the mount dir) if the path is under the mount prefix
+func (m *staticMount) match(p string) (string, bool) {
+	if !strings.HasPrefix(p, m.prefix) {
+		return "", false
+	}
+	name := p[len(m.prefix):]
+	if name != "" && name[0] != '/' {
+		return "", false
+	}
+	return name, true
+}
+
+// serve writes the file (or the directory index/listing) to the response, it returns false if nothing was found.
+//
+// The name is cleaned before opening the file so it cannot escape the directory.
+func (m *staticMount) serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if strings.Contains(name, "\x00") {
+		return false
+	}
+	name = path.Clean("/" + name)
+	f, err := m.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	if !fi.IsDir() {
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return true
+	}
+
+	// Redirect to the canonical directory path (with a trailing slash) so relative links works
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return true
+	}
+
+	// Serve the directory index if any
+	if index, err := m.fs.Open(path.Join(name, "index.html")); err == nil {
+		defer index.Close()
+		if ifi, err := index.Stat(); err == nil && !ifi.IsDir() {
+			http.ServeContent(w, r, ifi.Name(), ifi.ModTime(), index)
+			return true
+		}
+	}
+
+	if !m.listing {
+		return false
+	}
+
+	files, err := f.Readdir(-1)
+	if err != nil {
+		return false
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return true
+	}
+	fmt.Fprintf(w, "<pre>\n")
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+	return true
+}
@@ -0,0 +1,78 @@
+package gluapp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStaticMounts(t *testing.T) {
+	app, err := NewApp(&Config{
+		Path:             "tests_data/app/",
+		StaticMounts:     map[string]string{"/docs": "docs", "/shared": "../"},
+		StaticDirListing: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	testData := []struct {
+		method                     string
+		path                       string
+		expectedResponseBody       string
+		expectedResponseStatusCode int
+	}{
+		// Mounted via `router:static`
+		{"GET", "/assets/css/site.css", "body { color: red; }\n", 200},
+		{"GET", "/assets/", "assets index\n", 200},
+		{"GET", "/assets", "assets index\n", 200},
+		{"HEAD", "/assets/css/site.css", "", 200},
+		{"GET", "/assets/../app.lua", "Not Found", 404},
+		{"GET", "/assets/%2e%2e/app.lua", "Not Found", 404},
+		// Mounted via `Config.StaticMounts`
+		{"GET", "/docs/a.txt", "a\n", 200},
+		{"GET", "/docs/sub/b.txt", "b\n", 200},
+		{"GET", "/docs/", "<pre>\n<a href=\"a.txt\">a.txt</a>\n<a href=\"sub/\">sub/</a>\n</pre>\n", 200},
+		{"GET", "/docs/../app.lua", "Not Found", 404},
+		{"GET", "/docsnope", "Not Found", 404},
+		// Relative mounts may point outside of the app directory
+		{"GET", "/shared/hello.html", "Hello {{ .world }}!\n", 200},
+	}
+
+	for _, tdata := range testData {
+		req, err := http.NewRequest(tdata.method, server.URL+tdata.path, nil)
+		if err != nil {
+			panic(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if resp.StatusCode != tdata.expectedResponseStatusCode {
+			t.Errorf("%s %s: bad status code, got %d, expected %d", tdata.method, tdata.path, resp.StatusCode, tdata.expectedResponseStatusCode)
+		}
+		if string(body) != tdata.expectedResponseBody {
+			t.Errorf("%s %s: bad body, got %q, expected %q", tdata.method, tdata.path, body, tdata.expectedResponseBody)
+		}
+		if tdata.method == "HEAD" && !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/css") {
+			t.Errorf("%s %s: bad content type, got %q", tdata.method, tdata.path, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestStaticMountsOutsideFS(t *testing.T) {
+	_, err := NewApp(&Config{FS: testFSApp, StaticMounts: map[string]string{"/shared": "../shared"}})
+	if err == nil || !strings.Contains(err.Error(), "outside of the app filesystem") {
+		t.Errorf("expected an error for a mount outside of the app filesystem, got %v", err)
+	}
+}
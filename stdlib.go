@@ -0,0 +1,338 @@
+package gluapp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"a4.io/blobstash/pkg/apps/luautil"
+	"a4.io/gluapp/util"
+	"a4.io/gluarequire2"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Stdlib installs a selection of the gluapp modules in a Lua state, so embedders can pick exactly what they expose:
+//
+//	if err := gluapp.NewStdlib(conf).With("json", "template").Install(L); err != nil {
+//		return err
+//	}
+//
+// The dependencies of the selected modules are installed too (e.g. the shared metatables needed by `url`). The
+// `app` global and the `router` module are only available for a request (see `Stdlib.Request`).
+type Stdlib struct {
+	conf    *Config
+	fsys    fs.FS   // Filesystem the app files are read from (`conf.Path` is used if nil)
+	assets  *assets // Fingerprinted assets from public/ (only set for apps)
+	cache   *Cache
+	w       http.ResponseWriter
+	r       *http.Request
+	modules []string
+	all     bool
+
+	resp *Response // Response of the request, set by the `app` component
+}
+
+// component represents an installable module (or global variable)
+type component struct {
+	deps    []string
+	request bool // True if the component is request specific
+	install func(L *lua.LState, s *Stdlib) error
+}
+
+// Components, in installation order
+var componentsOrder = []string{
+	"require", "require2", "metatables", "util", "cmd", "fs", "kv", "cache", "sql", "redis", "log", "app", "router", "json", "http", "url",
+	"form", "template",
+}
+
+var components = map[string]*component{
+	// Lookup the Lua modules in the app directory/filesystem
+	"require": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			if s.fsys != nil {
+				setupFSLoader(L, s.fsys)
+			} else if s.conf.Path != "" {
+				path := L.GetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "path").(lua.LString)
+				path = lua.LString(s.conf.Path + "/?.lua;" + string(path))
+				L.SetField(L.GetField(L.Get(lua.EnvironIndex), "package"), "path", lua.LString(path))
+			}
+			return nil
+		},
+	},
+	"require2": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			gluarequire2.NewRequire2Module(gluarequire2.NewRequireFromGitHub(nil)).SetGlobal(L)
+			return nil
+		},
+	},
+	// Shared Lua metatables (used by multiple modules)
+	"metatables": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			setupMetatable(L)
+			return nil
+		},
+	},
+	"util": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			util.SetupUtil(L, s.conf.Path, s.fsys)
+			return nil
+		},
+	},
+	// Processes are killed when the request is canceled
+	"cmd": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			ctx := context.Background()
+			if s.r != nil {
+				ctx = s.r.Context()
+			}
+			util.SetupCmdContext(L, ctx, s.conf.Path)
+			return nil
+		},
+	},
+	// Read/write access to the data/ directory
+	"fs": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			L.PreloadModule("fs", setupDataFS(dataPath(s.conf)))
+			return nil
+		},
+	},
+	// Key-value store (shared by all the requests)
+	"kv": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			L.PreloadModule("kv", setupKV(kvPath(s.conf)))
+			return nil
+		},
+	},
+	"cache": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			c := s.cache
+			if c == nil {
+				c = NewCache(defaultCacheMaxSize)
+			}
+			ctx := context.Background()
+			if s.r != nil {
+				ctx = s.r.Context()
+			}
+			L.PreloadModule("cache", setupCache(ctx, c))
+			return nil
+		},
+	},
+	// Databases from `Config.Databases` (the queries are canceled with the request)
+	"sql": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			ctx := context.Background()
+			if s.r != nil {
+				ctx = s.r.Context()
+			}
+			L.PreloadModule("sql", setupSQL(ctx, s.conf.Databases))
+			return nil
+		},
+	},
+	// Client for the `Config.Redis` server (subscriptions are stopped with the request)
+	"redis": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			ctx := context.Background()
+			if s.r != nil {
+				ctx = s.r.Context()
+			}
+			L.PreloadModule("redis", setupRedis(ctx, s.conf.Redis))
+			return nil
+		},
+	},
+	"log": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			mod := setupLog(L, s.conf, requestID(s.r))
+			L.PreloadModule("log", func(L *lua.LState) int {
+				L.Push(mod)
+				return 1
+			})
+			L.SetGlobal("log", mod)
+			return nil
+		},
+	},
+	// The `app` global variable (with the request and the response)
+	"app": {
+		deps:    []string{"metatables"},
+		request: true,
+		install: func(L *lua.LState, s *Stdlib) error {
+			// Setup `request`
+			req, err := newRequest(L, s.r)
+			if err != nil {
+				return err
+			}
+			// Initialize `response`
+			resp, lresp := newResponse(L, s.w, s.r)
+			lresp.reqBody = req.Value.(*request).body
+
+			rootTable := L.CreateTable(0, 4)
+			rootTable.RawSetH(lua.LString("request"), req)
+			rootTable.RawSetH(lua.LString("response"), resp)
+			if s.conf.ContextToLua != nil {
+				rootTable.RawSetH(lua.LString("context"), luautil.InterfaceToLValue(L, s.conf.ContextToLua(s.r.Context())))
+			}
+			rootTable.RawSetH(lua.LString("asset_url"), L.NewFunction(func(L *lua.LState) int {
+				L.Push(lua.LString(scriptRoot(s.r) + s.assets.url(L.CheckString(1))))
+				return 1
+			}))
+			L.SetGlobal("app", rootTable)
+
+			s.resp = lresp
+			return nil
+		},
+	},
+	"router": {
+		deps:    []string{"app"},
+		request: true,
+		install: func(L *lua.LState, s *Stdlib) error {
+			L.PreloadModule("router", setupRouter(s.resp, s.r.Method, s.r.URL.Path, appFS(s.conf, s.fsys), nil))
+			return nil
+		},
+	},
+	"json": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			L.PreloadModule("json", loadJSON)
+			return nil
+		},
+	},
+	"http": {
+		deps: []string{"metatables"},
+		install: func(L *lua.LState, s *Stdlib) error {
+			client := s.conf.Client
+			if client == nil {
+				client = http.DefaultClient
+			}
+			if s.conf.Metrics != nil {
+				client = s.conf.Metrics.instrumentClient(client)
+			}
+			L.PreloadModule("http", setupHTTP(client, s.conf.Path))
+			return nil
+		},
+	},
+	"url": {
+		deps: []string{"metatables"},
+		install: func(L *lua.LState, s *Stdlib) error {
+			L.PreloadModule("url", setupURL())
+			return nil
+		},
+	},
+	"form": {
+		deps: []string{"metatables"},
+		install: func(L *lua.LState, s *Stdlib) error {
+			L.PreloadModule("form", setupForm())
+			return nil
+		},
+	},
+	"template": {
+		install: func(L *lua.LState, s *Stdlib) error {
+			finalFuncs := getFuncMaps(s.conf.TemplateFuncMap, s.assets, scriptRoot(s.r))
+			templatesPath := filepath.Join(s.conf.Path, "templates")
+			if s.fsys != nil {
+				templatesPath = "templates"
+			}
+			L.PreloadModule("template", setupTemplate(s.fsys, templatesPath, finalFuncs, s.conf.MinifyTemplates))
+			return nil
+		},
+	},
+}
+
+// StdlibModules returns the names of the modules that can be selected with `Stdlib.With`.
+func StdlibModules() []string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStdlib returns a new builder with no modules selected, the files are read from `conf.FS` if set (or from
+// `conf.Path`), and the `cache` module uses `conf.Cache` (a new cache is created for each `Install` if not set).
+func NewStdlib(conf *Config) *Stdlib {
+	return &Stdlib{
+		conf:  conf,
+		fsys:  conf.FS,
+		cache: conf.Cache,
+	}
+}
+
+// With selects modules to install.
+func (s *Stdlib) With(names ...string) *Stdlib {
+	s.modules = append(s.modules, names...)
+	return s
+}
+
+// All selects every module (the request specific ones are only installed if a request is set).
+func (s *Stdlib) All() *Stdlib {
+	s.all = true
+	return s
+}
+
+// Request sets the request, needed by the `app` global and the `router` module.
+func (s *Stdlib) Request(w http.ResponseWriter, r *http.Request) *Stdlib {
+	s.w = w
+	s.r = r
+	return s
+}
+
+// resolve returns the set of components to install, including the dependencies
+func (s *Stdlib) resolve() (map[string]bool, error) {
+	selected := map[string]bool{}
+	var add func(name string) error
+	add = func(name string) error {
+		c, ok := components[name]
+		if !ok {
+			return fmt.Errorf("unknown module %q", name)
+		}
+		if c.request && s.r == nil {
+			return fmt.Errorf("module %q needs a request", name)
+		}
+		if selected[name] {
+			return nil
+		}
+		selected[name] = true
+		for _, dep := range c.deps {
+			if err := add(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if s.all {
+		for name, c := range components {
+			if !c.request || s.r != nil {
+				selected[name] = true
+			}
+		}
+	}
+	for _, name := range s.modules {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+// Install installs the selected modules (and their dependencies) in the Lua state.
+func (s *Stdlib) Install(L *lua.LState) error {
+	selected, err := s.resolve()
+	if err != nil {
+		return err
+	}
+	for _, name := range componentsOrder {
+		if !selected[name] {
+			continue
+		}
+		if err := components[name].install(L, s); err != nil {
+			return err
+		}
+	}
+
+	// Restrict what the Lua code can do if needed
+	setupSandbox(L, s.conf.Capabilities)
+	return nil
+}
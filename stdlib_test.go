@@ -0,0 +1,42 @@
+package gluapp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestStdlib(t *testing.T) {
+	testData := []struct {
+		modules     []string
+		request     bool
+		code        string
+		expectedErr bool
+	}{
+		// The `values` metatable is installed as a dependency
+		{[]string{"url"}, false, `assert(require('url').parse('http://a.com?q=1').query:get('q') == '1')`, false},
+		{[]string{"json"}, false, `assert(require('json').encode({1}) == '[1]')`, false},
+		{[]string{"json"}, false, `require('http')`, true},
+		{[]string{"json"}, false, `assert(cmd == nil and log == nil and require2 == nil)`, false},
+		{[]string{"router"}, true, `assert(app.request:path() == '/foo'); require('router')`, false},
+		{[]string{"router"}, false, ``, true},
+		{[]string{"nope"}, false, ``, true},
+	}
+
+	for _, tdata := range testData {
+		L := lua.NewState()
+		s := NewStdlib(&Config{}).With(tdata.modules...)
+		if tdata.request {
+			s.Request(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil))
+		}
+		err := s.Install(L)
+		if err == nil {
+			err = L.DoString(tdata.code)
+		}
+		if (err != nil) != tdata.expectedErr {
+			t.Errorf("unexpected error for %v %q: %v", tdata.modules, tdata.code, err)
+		}
+		L.Close()
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"io/fs"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"a4.io/blobstash/pkg/apps/luautil"
+	"a4.io/gluapp/util"
 	"mvdan.cc/xurls"
 
 	"github.com/yuin/goldmark"
@@ -58,7 +61,16 @@ var funcs = template.FuncMap{
 	},
 }
 
-func setupTemplate(path string, funcMap template.FuncMap) func(*lua.LState) int {
+// setupTemplate returns the template module loader, if `minify` is set, the rendered output is minified as HTML.
+//
+// Templates are loaded from the filesystem if `fsys` is set, from the disk otherwise.
+func setupTemplate(fsys fs.FS, dir string, funcMap template.FuncMap, minify bool) func(*lua.LState) int {
+	output := func(out string) lua.LValue {
+		if minify {
+			return lua.LString(minifyHTML(out))
+		}
+		return lua.LString(out)
+	}
 	return func(L *lua.LState) int {
 		// Setup the router module
 		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
@@ -73,7 +85,7 @@ func setupTemplate(path string, funcMap template.FuncMap) func(*lua.LState) int
 					L.Push(lua.LString(err.Error()))
 					return 1
 				}
-				L.Push(lua.LString(out.String()))
+				L.Push(output(out.String()))
 				return 1
 			},
 			"render": func(L *lua.LState) int {
@@ -82,10 +94,26 @@ func setupTemplate(path string, funcMap template.FuncMap) func(*lua.LState) int
 				var templates []string
 				for i := 1; i < L.GetTop(); i++ {
 					// FIXME: remove dot in the filename
-					templates = append(templates, filepath.Join(path, string(L.ToString(i))))
+					if fsys != nil {
+						templates = append(templates, path.Join(dir, fsPath(L.ToString(i))))
+						continue
+					}
+					// The templates must stay in the templates directory
+					p, err := util.Resolve(dir, L.ToString(i))
+					if err != nil {
+						L.Push(lua.LString(err.Error()))
+						return 1
+					}
+					templates = append(templates, p)
 				}
 
-				tmpl, err := template.New("").Funcs(funcMap).ParseFiles(templates...)
+				var tmpl *template.Template
+				var err error
+				if fsys != nil {
+					tmpl, err = template.New("").Funcs(funcMap).ParseFS(fsys, templates...)
+				} else {
+					tmpl, err = template.New("").Funcs(funcMap).ParseFiles(templates...)
+				}
 				if err != nil {
 					L.Push(lua.LString(err.Error()))
 					return 1
@@ -97,7 +125,7 @@ func setupTemplate(path string, funcMap template.FuncMap) func(*lua.LState) int
 					return 1
 				}
 
-				L.Push(lua.LString(out.String()))
+				L.Push(output(out.String()))
 				return 1
 			},
 		})
@@ -12,7 +12,7 @@ func TestTemplate(t *testing.T) {
 	defer L.Close()
 
 	// Setup the state
-	L.PreloadModule("template", setupTemplate("tests_data/", funcs))
+	L.PreloadModule("template", setupTemplate(nil, "tests_data/", funcs, false))
 	setupTestState(L, t)
 
 	// Execute the Lua code
@@ -43,3 +43,26 @@ end
 		panic(err)
 	}
 }
+
+func TestTemplateMinify(t *testing.T) {
+	// Create a new empty state
+	L := lua.NewState()
+	defer L.Close()
+
+	// Setup the state
+	L.PreloadModule("template", setupTemplate(nil, "tests_data/", funcs, true))
+	setupTestState(L, t)
+
+	// Execute the Lua code
+	if err := L.DoString(`
+tpl = require('template')
+
+out = tpl.render_string('<p>\n  Hello   <b>{{.world}}</b>  <!-- comment -->\n</p>\n', {world = 'World'})
+expected = '<p> Hello <b>World</b> </p>'
+if out ~= expected then
+  errorf('template.render_string error, got %v, expected %v', out, expected)
+end
+`); err != nil {
+		panic(err)
+	}
+}
@@ -1,12 +1,35 @@
 router = require('router').new()
 print('inside app')
 
+router:static('/assets', 'assets')
+
 router:get('/', function()
   app.response:write('hello app')
 end)
 
 router:get('/bar', function()
   app.response:write('bar')
+end, {summary = 'Bar page'})
+
+router:get('/routes', function()
+  local out = {}
+  for _, r in ipairs(router:routes()) do
+    table.insert(out, r.method .. ' ' .. r.path)
+  end
+  app.response:write(table.concat(out, ','))
+end)
+
+router:post('/posts/:id', function(params)
+  app.response:jsonify({id = params.id})
+end, {
+  summary = 'Update a post',
+  request_body = {schema = {type = 'object'}},
+  responses = {['200'] = {description = 'The post', schema = {type = 'object'}}},
+})
+
+router:get('/assets_urls', function()
+  local tpl = require('template')
+  app.response:write(app.asset_url('/lol.html') .. '\n' .. tpl.render('assets.html', {}))
 end)
 
 router:run()
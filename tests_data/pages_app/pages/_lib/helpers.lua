@@ -0,0 +1,7 @@
+local helpers = {}
+
+function helpers.title(slug)
+  return 'post ' .. slug
+end
+
+return helpers
@@ -0,0 +1,2 @@
+local helpers = require('pages/_lib/helpers')
+app.response:write(helpers.title(app.params.slug))
@@ -0,0 +1 @@
+app.response:write('new post')
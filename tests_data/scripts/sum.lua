@@ -0,0 +1,12 @@
+local json = require('json')
+
+if #arg < 2 then
+  return 2
+end
+
+local total = 0
+for i = 1, #arg do
+  total = total + tonumber(arg[i])
+end
+print(arg[0] .. ': ' .. json.encode({total = total}))
+output = total
@@ -0,0 +1,201 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// The `cmd` module executes processes:
+//
+//	local cmd = require('cmd')
+//	local res = cmd.run({'git', 'log', '--oneline'}, {
+//	  dir = 'repo',              -- relative to the app path (and inside of it)
+//	  env = {GIT_PAGER = 'cat'}, -- added to the current environment
+//	  stdin = '',
+//	  timeout = 10,              -- in seconds
+//	  on_line = function(line, stream)
+//	    -- called for each output line, stream is "stdout" or "stderr"
+//	  end,
+//	})
+//	-- res = {stdout = '...', stderr = '...', exit_code = 0, duration = 0.01}
+//
+// A non-zero exit code is not an error, but failing to start the process, a timeout, or the request being canceled are
+// (the process is killed).
+
+// cmdLine is an output line sent to the `on_line` callback
+type cmdLine struct {
+	line   string
+	stream string
+}
+
+// readLines reads the output line by line, it's copied to the buffer and each line sent to the channel
+func readLines(r io.Reader, buf *bytes.Buffer, stream string, lines chan<- cmdLine) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			buf.WriteString(line)
+			lines <- cmdLine{strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), stream}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runCmd implements `cmd.run`
+func runCmd(L *lua.LState, ctx context.Context, cwd string) int {
+	tbl := L.CheckTable(1)
+	opts := L.OptTable(2, L.NewTable())
+	var args []string
+	for i := 1; i <= tbl.Len(); i++ {
+		args = append(args, tbl.RawGetInt(i).String())
+	}
+	if len(args) == 0 {
+		L.ArgError(1, "the command is empty")
+	}
+
+	parent := ctx
+	if timeout, ok := opts.RawGetString("timeout").(lua.LNumber); ok && timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(float64(timeout)*float64(time.Second)))
+		defer cancel()
+	}
+	// Needed to kill the process if the callback raises an error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = cwd
+	if dir, ok := opts.RawGetString("dir").(lua.LString); ok {
+		p, err := Resolve(cwd, string(dir))
+		if err != nil {
+			L.ArgError(2, fmt.Sprintf("invalid dir %q: %v", string(dir), err))
+		}
+		cmd.Dir = p
+	}
+	if env, ok := opts.RawGetString("env").(*lua.LTable); ok {
+		var vars []string
+		env.ForEach(func(k, v lua.LValue) {
+			vars = append(vars, k.String()+"="+v.String())
+		})
+		sort.Strings(vars)
+		cmd.Env = append(os.Environ(), vars...)
+	}
+	if stdin, ok := opts.RawGetString("stdin").(lua.LString); ok {
+		cmd.Stdin = strings.NewReader(string(stdin))
+	}
+
+	onLine, _ := opts.RawGetString("on_line").(*lua.LFunction)
+	outPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		L.RaiseError("failed to run %q: %v", args[0], err)
+	}
+	errPipe, err := cmd.StderrPipe()
+	if err != nil {
+		L.RaiseError("failed to run %q: %v", args[0], err)
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		L.RaiseError("failed to run %q: %v", args[0], err)
+	}
+	// Child processes may keep the pipes open once the process is killed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			outPipe.Close()
+			errPipe.Close()
+		case <-done:
+		}
+	}()
+
+	// The output is read in goroutines, but the callback must be called from this one
+	var stdout, stderr bytes.Buffer
+	lines := make(chan cmdLine)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		readLines(outPipe, &stdout, "stdout", lines)
+	}()
+	go func() {
+		defer wg.Done()
+		readLines(errPipe, &stderr, "stderr", lines)
+	}()
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	var cbErr error
+	for l := range lines {
+		if onLine == nil || cbErr != nil {
+			continue
+		}
+		L.Push(onLine)
+		L.Push(lua.LString(l.line))
+		L.Push(lua.LString(l.stream))
+		if cbErr = L.PCall(2, 0, nil); cbErr != nil {
+			cancel()
+		}
+	}
+	err = cmd.Wait()
+	if cbErr != nil {
+		if lerr, ok := cbErr.(*lua.ApiError); ok {
+			L.Error(lerr.Object, 0)
+		}
+		L.RaiseError("%v", cbErr)
+	}
+	duration := time.Since(start)
+
+	switch {
+	case parent.Err() != nil:
+		L.RaiseError("%q was killed: %v", args[0], parent.Err())
+	case ctx.Err() != nil:
+		L.RaiseError("%q timed out after %v", args[0], duration.Round(time.Millisecond))
+	}
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			L.RaiseError("failed to run %q: %v", args[0], err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	res := L.CreateTable(0, 4)
+	res.RawSetString("stdout", lua.LString(stdout.String()))
+	res.RawSetString("stderr", lua.LString(stderr.String()))
+	res.RawSetString("exit_code", lua.LNumber(exitCode))
+	res.RawSetString("duration", lua.LNumber(duration.Seconds()))
+	L.Push(res)
+	return 1
+}
+
+// Return a module with a single "run" function that run processes (killed when the context is canceled).
+func setupCmd(ctx context.Context, cwd string) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"run": func(L *lua.LState) int {
+				return runCmd(L, ctx, cwd)
+			},
+		})
+		// returns the module
+		L.Push(mod)
+		return 1
+	}
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideRoot is returned by `Resolve` for the paths leaving the root directory.
+var ErrOutsideRoot = errors.New("path outside of the root directory")
+
+// Resolve returns the absolute path of the file relative to root, or `ErrOutsideRoot` if it's not inside root
+// (absolute paths, ".." escapes and symlinks pointing outside of root are rejected).
+func Resolve(root, name string) (string, error) {
+	name = filepath.ToSlash(name)
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) || strings.ContainsRune(name, 0) {
+		return "", ErrOutsideRoot
+	}
+	rel := path.Clean(name)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", ErrOutsideRoot
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(root, filepath.FromSlash(rel))
+
+	// Ensure no symlink points outside of the root (checking the deepest existing parent)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return "", err
+	}
+	for existing := p; ; existing = filepath.Dir(existing) {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if real != realRoot && !strings.HasPrefix(real, realRoot+string(filepath.Separator)) {
+				return "", ErrOutsideRoot
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if existing == root {
+			break
+		}
+	}
+	return p, nil
+}
@@ -2,11 +2,13 @@
 package util // import "a4.io/gluapp/util"
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -17,13 +19,45 @@ import (
 )
 
 func Setup(L *lua.LState, cwd string) {
-	L.PreloadModule("cmd", setupCmd(cwd))
-	L.PreloadModule("util", setupUtil(cwd))
+	SetupFS(L, cwd, nil)
+}
+
+// SetupFS is like Setup, but `read_file`/`read_yaml` read from the given filesystem (if not nil).
+func SetupFS(L *lua.LState, cwd string, fsys fs.FS) {
+	SetupCmd(L, cwd)
+	SetupUtil(L, cwd, fsys)
+}
+
+// SetupUtil only preloads the `util` module.
+func SetupUtil(L *lua.LState, cwd string, fsys fs.FS) {
+	L.PreloadModule("util", setupUtil(cwd, fsys))
+}
+
+// SetupCmd only preloads the `cmd` module.
+func SetupCmd(L *lua.LState, cwd string) {
+	SetupCmdContext(L, context.Background(), cwd)
+}
+
+// SetupCmdContext is like SetupCmd, but the processes are killed when the context is canceled.
+func SetupCmdContext(L *lua.LState, ctx context.Context, cwd string) {
+	L.PreloadModule("cmd", setupCmd(ctx, cwd))
+}
+
+// readFile reads the file from the filesystem if any, relative to cwd otherwise (and it must be inside cwd)
+func readFile(cwd string, fsys fs.FS, name string) ([]byte, error) {
+	if fsys != nil {
+		return fs.ReadFile(fsys, path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/")))
+	}
+	p, err := Resolve(cwd, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
 }
 
 // Return a module with a single "run" function that run CLI commands and return the error
 // as a string.
-func setupUtil(cwd string) func(*lua.LState) int {
+func setupUtil(cwd string, fsys fs.FS) func(*lua.LState) int {
 	return func(L *lua.LState) int {
 		// register functions to the table
 		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
@@ -36,7 +70,7 @@ func setupUtil(cwd string) func(*lua.LState) int {
 				return 1
 			},
 			"read_yaml": func(L *lua.LState) int {
-				data, err := ioutil.ReadFile(filepath.Join(cwd, L.ToString(1)))
+				data, err := readFile(cwd, fsys, L.ToString(1))
 				if err != nil {
 					panic(err)
 				}
@@ -48,13 +82,17 @@ func setupUtil(cwd string) func(*lua.LState) int {
 				return 1
 			},
 			"delete_file": func(L *lua.LState) int {
-				if err := os.Remove(filepath.Join(cwd, L.ToString(1))); err != nil {
+				p, err := Resolve(cwd, L.ToString(1))
+				if err != nil {
+					panic(err)
+				}
+				if err := os.Remove(p); err != nil {
 					panic(err)
 				}
 				return 0
 			},
 			"read_file": func(L *lua.LState) int {
-				data, err := ioutil.ReadFile(filepath.Join(cwd, L.ToString(1)))
+				data, err := readFile(cwd, fsys, L.ToString(1))
 				if err != nil {
 					panic(err)
 				}
@@ -66,28 +104,3 @@ func setupUtil(cwd string) func(*lua.LState) int {
 		return 1
 	}
 }
-
-// Return a module with a single "run" function that run CLI commands and return the error
-// as a string.
-func setupCmd(cwd string) func(*lua.LState) int {
-	return func(L *lua.LState) int {
-		// register functions to the table
-		mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
-			"run": func(L *lua.LState) int {
-				parts := strings.Split(L.ToString(1), " ")
-				cmd := exec.Command(parts[0], parts[1:]...)
-				cmd.Dir = cwd
-				err := cmd.Run()
-				var out string
-				if err != nil {
-					out = err.Error()
-				}
-				L.Push(lua.LString(out))
-				return 1
-			},
-		})
-		// returns the module
-		L.Push(mod)
-		return 1
-	}
-}